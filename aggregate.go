@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Stats summarizes the values Aggregate extracted. Min, Max and Avg are 0
+// when Count is.
+type Stats struct {
+	Count int
+	Sum   float64
+	Min   float64
+	Max   float64
+	Avg   float64
+}
+
+// Aggregate decodes every record in collection into T and summarizes the
+// values extract returns for them. Records that fail to decode are skipped
+// with a warning.
+func Aggregate[T any](d *Driver, collection string, extract func(T) float64) (Stats, error) {
+	var stats Stats
+
+	if collection == "" {
+		return stats, fmt.Errorf("missing collection - unable to read")
+	}
+	if extract == nil {
+		return stats, fmt.Errorf("missing extract - unable to aggregate")
+	}
+
+	stats.Min, stats.Max = math.Inf(1), math.Inf(-1)
+
+	_, err := eachDecoded(d, collection, func(v T) {
+		x := extract(v)
+		stats.Count++
+		stats.Sum += x
+		stats.Min = math.Min(stats.Min, x)
+		stats.Max = math.Max(stats.Max, x)
+	})
+	if err != nil {
+		return Stats{}, err
+	}
+
+	if stats.Count == 0 {
+		return Stats{}, nil
+	}
+	stats.Avg = stats.Sum / float64(stats.Count)
+	return stats, nil
+}
+
+// eachDecoded calls fn with every record in collection that decodes into T,
+// in resource name order, and returns how many were skipped because they did
+// not.
+func eachDecoded[T any](d *Driver, collection string, fn func(v T)) (skipped int, err error) {
+	leave, err := d.enter()
+	if err != nil {
+		return 0, err
+	}
+	defer leave()
+
+	resources, read, err := d.records(collection)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, resource := range resources {
+		b, err := read(resource)
+		if err != nil {
+			return skipped, err
+		}
+
+		var v T
+		if err := d.decode(b, &v); err != nil {
+			d.log.Warn("Skipping %v\n", decodeError(collection, resource, b, err))
+			skipped++
+			continue
+		}
+		fn(v)
+	}
+	return skipped, nil
+}
+
+// GroupBy decodes every record in collection into T and buckets them by the
+// key key returns, each bucket in resource name order. Records that fail to
+// decode are skipped with a warning and counted in skipped.
+func GroupBy[T any, K comparable](d *Driver, collection string, key func(T) K) (groups map[K][]T, skipped int, err error) {
+	if collection == "" {
+		return nil, 0, fmt.Errorf("missing collection - unable to read")
+	}
+	if key == nil {
+		return nil, 0, fmt.Errorf("missing key - unable to group")
+	}
+
+	groups = make(map[K][]T)
+
+	skipped, err = eachDecoded(d, collection, func(v T) {
+		k := key(v)
+		groups[k] = append(groups[k], v)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return groups, skipped, nil
+}
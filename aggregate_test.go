@@ -0,0 +1,59 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func age(u User) float64 {
+	f, _ := u.Age.Float64()
+	return f
+}
+
+func TestAggregateAge(t *testing.T) {
+	logger := &testLogger{}
+	d := newTestDriver(t, WithLogger(logger))
+	writeDemoUsers(t, d)
+	mustWrite(t, d, "users", map[string]interface{}{"Broken": "not a user"})
+
+	stats, err := Aggregate(d, "users", age)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Stats{Count: 6, Sum: 158, Min: 23, Max: 29, Avg: 158.0 / 6}
+	if stats.Count != want.Count || stats.Sum != want.Sum || stats.Min != want.Min ||
+		stats.Max != want.Max || math.Abs(stats.Avg-want.Avg) > 1e-9 {
+		t.Fatalf("Aggregate = %+v, want %+v", stats, want)
+	}
+	if len(logger.Warnings()) != 1 {
+		t.Fatalf("skipping the broken record logged %q", logger.Warnings())
+	}
+}
+
+func TestGroupByCity(t *testing.T) {
+	d := newTestDriver(t)
+	writeDemoUsers(t, d)
+	mustWrite(t, d, "users", map[string]interface{}{"Broken": "not a user"})
+
+	groups, skipped, err := GroupBy(d, "users", func(u User) string { return u.Address.City })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped != 1 {
+		t.Errorf("GroupBy skipped %d records, want 1", skipped)
+	}
+	if len(groups) != 5 {
+		t.Errorf("GroupBy made %d groups, want 5", len(groups))
+	}
+
+	names := map[string]bool{}
+	for _, u := range groups["Bangalore"] {
+		names[u.Name] = true
+	}
+	if len(groups["Bangalore"]) != 2 || !names["John"] || !names["Jane"] {
+		t.Fatalf("Bangalore holds %+v, want John and Jane", groups["Bangalore"])
+	}
+	if len(groups["Kolkata"]) != 1 || groups["Kolkata"][0].Name != "Arnab" {
+		t.Fatalf("Kolkata holds %+v, want Arnab", groups["Kolkata"])
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// blobFile returns the file a record's binary attachment is stored in.
+func (d *Driver) blobFile(collection, resource string) string {
+	return d.recordBase(collection, resource) + ".blob"
+}
+
+// WriteBlob stores the bytes read from r as a binary attachment of the
+// record, replacing any previous one. Like Write, the bytes go to a temp
+// file that is renamed into place once complete.
+func (d *Driver) WriteBlob(collection, resource string, r io.Reader) error {
+	leave, err := d.enter()
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if collection == "" {
+		return fmt.Errorf("missing collection - no place to save blob")
+	}
+	if resource == "" {
+		return fmt.Errorf("missing resource - unable to save blob (no name)")
+	}
+
+	mutex := d.getOrCreateMutex(collection)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if err := d.checkPath(collection, resource); err != nil {
+		return err
+	}
+	path := d.blobFile(collection, resource)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return diskError(err)
+	}
+
+	return d.writeStream(path, func(w io.Writer) error {
+		_, err := io.Copy(w, r)
+		return err
+	})
+}
+
+// ReadBlob opens the binary attachment of a record. The caller must close
+// it.
+func (d *Driver) ReadBlob(collection, resource string) (io.ReadCloser, error) {
+	leave, err := d.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	if collection == "" {
+		return nil, fmt.Errorf("missing collection - unable to read")
+	}
+	if resource == "" {
+		return nil, fmt.Errorf("missing resource - unable to read blob (no name)")
+	}
+
+	if err := d.checkPath(collection, resource); err != nil {
+		return nil, err
+	}
+	path := d.blobFile(collection, resource)
+	if !d.opts.FollowSymlinks {
+		if _, err := d.resolve(path); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlobRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+	writeDemoUsers(t, d)
+
+	avatar := bytes.Repeat([]byte{0x89, 'P', 'N', 'G', 0, 0xff}, 1000)
+	if err := d.WriteBlob("users", "Arnab", bytes.NewReader(avatar)); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := d.ReadBlob("users", "Arnab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, avatar) {
+		t.Fatalf("read back %d bytes, want the %d written", len(got), len(avatar))
+	}
+
+	// The record itself is untouched and the blob is not a record.
+	var user User
+	if err := d.Read("users", "Arnab", &user); err != nil || user.Name != "Arnab" {
+		t.Fatalf("Read returned %+v, %v", user, err)
+	}
+	if keys, _ := d.Keys("users"); len(keys) != len(demoUsers()) {
+		t.Fatalf("Keys = %v", keys)
+	}
+
+	if err := d.Delete("users", "Arnab"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Arnab.json", "Arnab.blob"} {
+		if _, err := os.Stat(filepath.Join(dir, "users", name)); !os.IsNotExist(err) {
+			t.Errorf("%v still there after Delete: %v", name, err)
+		}
+	}
+	if _, err := d.ReadBlob("users", "Arnab"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("ReadBlob after Delete returned %v, want ErrNotFound", err)
+	}
+}
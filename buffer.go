@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// writeBuffer holds the records Write has staged under Options.BufferWrites
+// until they are flushed. Staging and flushing a collection both happen
+// under its collection lock.
+type writeBuffer struct {
+	mutex   sync.Mutex
+	pending map[string]map[string][]byte
+	count   int
+	timer   *time.Timer
+}
+
+func newWriteBuffer() *writeBuffer {
+	return &writeBuffer{pending: make(map[string]map[string][]byte)}
+}
+
+// stage holds b as resource in collection and reports whether it replaced a
+// staged record, along with how many records are now staged.
+func (w *writeBuffer) stage(collection, resource string, b []byte) (bool, int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	records, ok := w.pending[collection]
+	if !ok {
+		records = make(map[string][]byte)
+		w.pending[collection] = records
+	}
+
+	_, existed := records[resource]
+	if !existed {
+		w.count++
+	}
+	records[resource] = b
+	return existed, w.count
+}
+
+// get returns a staged record. It is safe to call on a nil buffer.
+func (w *writeBuffer) get(collection, resource string) ([]byte, bool) {
+	if w == nil {
+		return nil, false
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	b, ok := w.pending[collection][resource]
+	return b, ok
+}
+
+// discard drops a staged record, or every staged record of collection if
+// resource is empty, and reports whether there was any. It is safe to call
+// on a nil buffer.
+func (w *writeBuffer) discard(collection, resource string) bool {
+	if w == nil {
+		return false
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	records := w.pending[collection]
+	if resource == "" {
+		w.count -= len(records)
+		delete(w.pending, collection)
+		return len(records) > 0
+	}
+
+	if _, ok := records[resource]; !ok {
+		return false
+	}
+	delete(records, resource)
+	w.count--
+	return true
+}
+
+// take removes and returns the staged records of collection.
+func (w *writeBuffer) take(collection string) map[string][]byte {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	records := w.pending[collection]
+	w.count -= len(records)
+	delete(w.pending, collection)
+	return records
+}
+
+func (w *writeBuffer) collections() []string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	collections := make([]string, 0, len(w.pending))
+	for collection := range w.pending {
+		collections = append(collections, collection)
+	}
+	sort.Strings(collections)
+	return collections
+}
+
+// merge adds the staged records of collection to resources as listed from
+// disk with err, so a collection that only exists in the buffer lists too.
+func (w *writeBuffer) merge(collection string, resources []string, err error) ([]string, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	records := w.pending[collection]
+	if len(records) == 0 {
+		return resources, err
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	merged := append([]string(nil), resources...)
+	for resource := range records {
+		if i := sort.SearchStrings(resources, resource); i == len(resources) || resources[i] != resource {
+			merged = append(merged, resource)
+		}
+	}
+	sort.Strings(merged)
+	return merged, nil
+}
+
+// stageWrite is Write under Options.BufferWrites, with the collection lock
+// held. It reports whether the record already existed, staged or, under
+// Options.TrackOverwrites, on disk.
+func (d *Driver) stageWrite(collection, resource string, b []byte) (bool, int) {
+	existed := false
+	if d.opts.TrackOverwrites {
+		_, err := d.fetchRecord(collection, resource)
+		existed = err == nil
+	}
+
+	staged, count := d.buffer.stage(collection, resource, b)
+
+	d.buffer.mutex.Lock()
+	if d.opts.BufferMaxDelay > 0 && d.buffer.timer == nil {
+		d.buffer.timer = time.AfterFunc(d.opts.BufferMaxDelay, func() {
+			if err := d.Flush(); err != nil {
+				d.log.Error("Unable to flush buffered writes: %s\n", err)
+			}
+		})
+	}
+	d.buffer.mutex.Unlock()
+
+	return existed || staged, count
+}
+
+// flushIfFull flushes once staged records reach Options.BufferMaxRecords.
+// A Close that turns the flush away flushes them itself, so that is not an
+// error for the write that staged them.
+func (d *Driver) flushIfFull(staged int) error {
+	if d.opts.BufferMaxRecords == 0 || staged < d.opts.BufferMaxRecords {
+		return nil
+	}
+	if err := d.Flush(); err != nil && !errors.Is(err, ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// Flush writes every record staged under Options.BufferWrites to disk, then
+// fsyncs everything waiting on Options.SyncInterval. A record that fails to
+// write stays staged, and the failures are joined into the returned error.
+// Flush is a no-op without either option.
+func (d *Driver) Flush() error {
+	leave, err := d.enter()
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	return d.flushAll()
+}
+
+func (d *Driver) flushAll() error {
+	var errs []error
+
+	if d.buffer != nil {
+		d.buffer.mutex.Lock()
+		if d.buffer.timer != nil {
+			d.buffer.timer.Stop()
+			d.buffer.timer = nil
+		}
+		d.buffer.mutex.Unlock()
+
+		for _, collection := range d.buffer.collections() {
+			errs = append(errs, d.flush(collection))
+		}
+	}
+
+	errs = append(errs, d.syncer.sync())
+	return errors.Join(errs...)
+}
+
+func (d *Driver) flush(collection string) error {
+	mutex := d.getOrCreateMutex(collection)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return d.flushLocked(collection)
+}
+
+// flushLocked writes the staged records of collection to disk. The caller
+// must hold the collection lock.
+func (d *Driver) flushLocked(collection string) error {
+	if d.buffer == nil {
+		return nil
+	}
+
+	records := d.buffer.take(collection)
+
+	var errs []error
+	for _, resource := range sortedResources(records) {
+		if _, err := d.writeRecord(collection, resource, records[resource]); err != nil {
+			d.buffer.stage(collection, resource, records[resource])
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
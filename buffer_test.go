@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBufferedWritesInvisibleUntilFlush(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir, WithBufferWrites(0, 0))
+	other := openTestDriver(t, dir)
+
+	writeDemoUsers(t, d)
+
+	var user User
+	if err := d.Read("users", "Arnab", &user); err != nil || user.Name != "Arnab" {
+		t.Fatalf("writer cannot read its staged record: %+v, %v", user, err)
+	}
+	if keys, err := d.Keys("users"); err != nil || len(keys) != len(demoUsers()) {
+		t.Fatalf("writer Keys = %v, %v", keys, err)
+	}
+	if err := other.Read("users", "Arnab", &user); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("second driver read an unflushed record: %v", err)
+	}
+
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Read("users", "Arnab", &user); err != nil || user.Name != "Arnab" {
+		t.Fatalf("second driver after Flush read %+v, %v", user, err)
+	}
+}
+
+func TestBufferedWritesThresholds(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir, WithBufferWrites(3, 0))
+	other := openTestDriver(t, dir)
+
+	mustWrite(t, d, "users", map[string]interface{}{"a": 1, "b": 2})
+	if keys, _ := other.Keys("users"); len(keys) != 0 {
+		t.Fatalf("flushed before the threshold: %v", keys)
+	}
+	mustWrite(t, d, "users", map[string]interface{}{"c": 3})
+	if keys, _ := other.Keys("users"); len(keys) != 3 {
+		t.Fatalf("not flushed at the threshold: %v", keys)
+	}
+
+	timedDir := t.TempDir()
+	timed := openTestDriver(t, timedDir, WithBufferWrites(0, 10*time.Millisecond))
+	timedOther := openTestDriver(t, timedDir)
+	mustWrite(t, timed, "users", map[string]interface{}{"a": 1})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if keys, _ := timedOther.Keys("users"); len(keys) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("not flushed after BufferMaxDelay")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBufferedWritesFlushOnClose(t *testing.T) {
+	dir := t.TempDir()
+	d, err := New(dir, WithLogger(&testLogger{}), WithBufferWrites(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeDemoUsers(t, d)
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys, err := openTestDriver(t, dir).Keys("users"); err != nil || len(keys) != len(demoUsers()) {
+		t.Fatalf("Keys after Close = %v, %v", keys, err)
+	}
+}
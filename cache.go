@@ -0,0 +1,185 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// CacheStats counts how Read and ReadRaw fared against Options.CacheSize.
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+}
+
+// readCache is an LRU of record bytes keyed by collection and resource. A
+// generation counter per collection, bumped on every invalidation in it,
+// keeps a read that raced with a write from caching what it read before the
+// write, without writes elsewhere getting in the way.
+type readCache struct {
+	mutex   sync.Mutex
+	max     int
+	order   *list.List
+	entries map[cacheKey]*list.Element
+	gens    map[string]uint64
+	stats   CacheStats
+}
+
+type cacheKey struct {
+	collection, resource string
+}
+
+type cacheEntry struct {
+	key cacheKey
+	b   []byte
+}
+
+func newReadCache(max int) *readCache {
+	return &readCache{
+		max:     max,
+		order:   list.New(),
+		entries: make(map[cacheKey]*list.Element),
+		gens:    make(map[string]uint64),
+	}
+}
+
+// get returns a copy of a cached record, along with the generation to pass
+// to put on a miss. It is safe to call on a nil cache.
+func (c *readCache) get(collection, resource string) ([]byte, uint64, bool) {
+	if c == nil {
+		return nil, 0, false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	elem, ok := c.entries[cacheKey{collection, resource}]
+	if !ok {
+		c.stats.Misses++
+		return nil, c.gens[collection], false
+	}
+	c.stats.Hits++
+	c.order.MoveToFront(elem)
+	return append([]byte(nil), elem.Value.(*cacheEntry).b...), c.gens[collection], true
+}
+
+// put caches b unless collection was invalidated since gen was returned by
+// get. It is safe to call on a nil cache.
+func (c *readCache) put(collection, resource string, b []byte, gen uint64) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if gen != c.gens[collection] {
+		return
+	}
+
+	key := cacheKey{collection, resource}
+	b = append([]byte(nil), b...)
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*cacheEntry).b = b
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, b: b})
+	if c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// forget drops a cached record, or every cached record of collection if
+// resource is empty. It is safe to call on a nil cache.
+func (c *readCache) forget(collection, resource string) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.gens[collection]++
+
+	if resource != "" {
+		if elem, ok := c.entries[cacheKey{collection, resource}]; ok {
+			c.order.Remove(elem)
+			delete(c.entries, elem.Value.(*cacheEntry).key)
+		}
+		return
+	}
+
+	for key, elem := range c.entries {
+		if key.collection == collection {
+			c.order.Remove(elem)
+			delete(c.entries, key)
+		}
+	}
+}
+
+// forget invalidates what the driver holds of a record, or of every record
+// of collection if resource is empty, after it changed.
+func (d *Driver) forget(collection, resource string) {
+	d.cache.forget(collection, resource)
+	d.flights.forget(collection, resource)
+}
+
+// CacheStats reports the hits and misses of the read cache so far. Both are
+// zero without Options.CacheSize.
+func (d *Driver) CacheStats() CacheStats {
+	if d.cache == nil {
+		return CacheStats{}
+	}
+
+	d.cache.mutex.Lock()
+	defer d.cache.mutex.Unlock()
+	return d.cache.stats
+}
+
+// generation returns the generation of collection to pass to put. It is
+// safe to call on a nil cache.
+func (c *readCache) generation(collection string) uint64 {
+	if c == nil {
+		return 0
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.gens[collection]
+}
+
+// ReadFresh is Read bypassing Options.CacheSize: the record always comes
+// from disk, and the cache is refreshed with it, e.g. after the file was
+// changed by something other than this driver. Using it for every read
+// defeats the cache, so keep it for when staleness matters.
+func (d *Driver) ReadFresh(collection, resource string, v interface{}) error {
+	if collection == "" {
+		return fmt.Errorf("missing collection - unable to read")
+	}
+	if resource == "" {
+		return fmt.Errorf("missing resource - unable to read record (no name)")
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("%w: got %T", ErrNotPointer, v)
+	}
+
+	gen := d.cache.generation(collection)
+
+	b, err := d.readUncached(collection, resource)
+	if err != nil {
+		d.cache.forget(collection, resource)
+		return err
+	}
+	d.cache.put(collection, resource, b, gen)
+
+	if err := d.decode(b, v); err != nil {
+		return decodeError(collection, resource, b, err)
+	}
+	return nil
+}
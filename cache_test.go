@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// countingCompressor stores records as they are under its own extension and
+// counts the records read back from disk, standing in for a storage fake.
+type countingCompressor struct {
+	reads *int64
+}
+
+func newCountingCompressor() countingCompressor {
+	return countingCompressor{reads: new(int64)}
+}
+
+func (countingCompressor) Ext() string { return ".cnt" }
+
+func (countingCompressor) Compress(w io.Writer) io.WriteCloser { return nopWriteCloser{w} }
+
+func (c countingCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	atomic.AddInt64(c.reads, 1)
+	return r, nil
+}
+
+func (c countingCompressor) Reads() int64 { return atomic.LoadInt64(c.reads) }
+
+func TestCacheSecondReadSkipsDisk(t *testing.T) {
+	codec := newCountingCompressor()
+	d := newTestDriver(t, WithCacheSize(10), WithCompress(true), WithCompressor(codec))
+	writeDemoUsers(t, d)
+
+	var user User
+	for i := 0; i < 3; i++ {
+		if err := d.Read("users", "Arnab", &user); err != nil || user.Name != "Arnab" {
+			t.Fatalf("Read returned %+v, %v", user, err)
+		}
+	}
+	if reads := codec.Reads(); reads != 1 {
+		t.Fatalf("three reads hit disk %d times, want once", reads)
+	}
+	if stats := d.CacheStats(); stats.Hits != 2 || stats.Misses != 1 {
+		t.Fatalf("CacheStats = %+v, want 2 hits and 1 miss", stats)
+	}
+}
+
+func TestCacheInvalidatedByWrites(t *testing.T) {
+	d := newTestDriver(t, WithCacheSize(10))
+	writeDemoUsers(t, d)
+
+	var user User
+	if err := d.Read("users", "Arnab", &user); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Write("users", "Arnab", User{Name: "Arnab", Company: "Acme"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Read("users", "Arnab", &user); err != nil || user.Company != "Acme" {
+		t.Fatalf("Read after Write returned %+v, %v", user, err)
+	}
+
+	if err := d.SetField("users", "Arnab", "Company", "Initech"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Read("users", "Arnab", &user); err != nil || user.Company != "Initech" {
+		t.Fatalf("Read after SetField returned %+v, %v", user, err)
+	}
+
+	if err := d.Delete("users", "Arnab"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Read("users", "Arnab", &user); err == nil {
+		t.Fatal("Read after Delete served the cached record")
+	}
+}
+
+func TestCacheGenerationPerCollection(t *testing.T) {
+	c := newReadCache(4)
+	record := []byte(`{"Name": "Arnab"}`)
+
+	// A write elsewhere does not stop a read from caching what it read.
+	_, gen, _ := c.get("users", "Arnab")
+	c.forget("events", "e1")
+	c.put("users", "Arnab", record, gen)
+	if _, _, ok := c.get("users", "Arnab"); !ok {
+		t.Fatal("a write to another collection kept the read from being cached")
+	}
+
+	// A write to the same collection does.
+	_, gen, _ = c.get("users", "John")
+	c.forget("users", "Harry")
+	c.put("users", "John", record, gen)
+	if _, _, ok := c.get("users", "John"); ok {
+		t.Fatal("a read that raced with a write to its collection was cached")
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	codec := newCountingCompressor()
+	d := newTestDriver(t, WithCacheSize(2), WithCompress(true), WithCompressor(codec))
+	writeDemoUsers(t, d)
+
+	var user User
+	for _, resource := range []string{"Arnab", "John", "Arnab", "Harry", "Arnab", "John"} {
+		if err := d.Read("users", resource, &user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Arnab stays hot; John is evicted by Harry and read again.
+	if reads := codec.Reads(); reads != 4 {
+		t.Fatalf("%d disk reads, want 4", reads)
+	}
+}
+
+func TestReadFreshSeesOutOfBandChanges(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir, WithCacheSize(10))
+	writeDemoUsers(t, d)
+
+	var user User
+	if err := d.Read("users", "Arnab", &user); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(User{Name: "Arnab", Company: "Acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "users", "Arnab.json"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.ReadFresh("users", "Arnab", &user); err != nil || user.Company != "Acme" {
+		t.Fatalf("ReadFresh returned %+v, %v", user, err)
+	}
+	// The refreshed entry is what the cache serves from now on.
+	if err := d.Read("users", "Arnab", &user); err != nil || user.Company != "Acme" {
+		t.Fatalf("Read after ReadFresh returned %+v, %v", user, err)
+	}
+}
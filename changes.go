@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Change is a record written or deleted after the sequence passed to
+// Changes. A deleted Change with an empty Resource means the whole
+// collection was deleted, or renamed away, dropping every record before it.
+type Change struct {
+	Seq      uint64
+	Resource string
+	Deleted  bool
+
+	// Raw is the record as it is now, or nil if Deleted.
+	Raw []byte
+}
+
+type changeEntry struct {
+	Seq      uint64 `json:"seq"`
+	Resource string `json:"resource"`
+	Deleted  bool   `json:"deleted,omitempty"`
+}
+
+// changelogTail is how much of the end of a changelog is read to find the
+// last sequence number before falling back to reading all of it.
+const changelogTail = 4096
+
+// changelogFile returns the file Options.TrackChanges appends a collection's
+// changes to. It is kept outside the collection's directory, so deleting the
+// collection does not restart the sequence.
+func (d *Driver) changelogFile(collection string) string {
+	return filepath.Join(*d.dir, ".changes", collection+".jsonl")
+}
+
+// logChange appends resources to the changelog of collection under the next
+// sequence numbers. An empty resource logs that the whole collection was
+// dropped. The caller must hold the collection lock.
+func (d *Driver) logChange(collection string, deleted bool, resources ...string) error {
+	if !d.opts.TrackChanges || len(resources) == 0 {
+		return nil
+	}
+
+	path := d.changelogFile(collection)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return diskError(err)
+	}
+
+	seq, err := lastSeq(path)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, resource := range resources {
+		seq++
+		if err := enc.Encode(changeEntry{Seq: seq, Resource: resource, Deleted: deleted}); err != nil {
+			return err
+		}
+	}
+
+	release := d.acquireFile()
+	defer release()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return diskError(err)
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return diskError(err)
+	}
+	return diskError(f.Close())
+}
+
+// lastSeq returns the sequence number of the last entry in the changelog at
+// path, or 0 if there is none.
+func lastSeq(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	offset := fi.Size() - changelogTail
+	if offset < 0 {
+		offset = 0
+	}
+	b, err := ioutil.ReadAll(io.NewSectionReader(f, offset, fi.Size()-offset))
+	if err != nil {
+		return 0, err
+	}
+
+	b = bytes.TrimRight(b, "\n")
+	i := bytes.LastIndexByte(b, '\n')
+	if i < 0 && offset > 0 {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+		if b, err = ioutil.ReadAll(f); err != nil {
+			return 0, err
+		}
+		b = bytes.TrimRight(b, "\n")
+		i = bytes.LastIndexByte(b, '\n')
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	var entry changeEntry
+	if err := json.Unmarshal(b[i+1:], &entry); err != nil {
+		return 0, fmt.Errorf("unable to read changelog %v: %w", path, err)
+	}
+	return entry.Seq, nil
+}
+
+// Changes returns the records of collection written or deleted after the
+// sequence number afterSeq, each once with its latest state, in sequence
+// order, along with the sequence number to pass next time. A replica can
+// poll it starting from 0 to follow a primary. It requires
+// Options.TrackChanges. Deleting the whole collection keeps the sequence
+// counting, and is reported as a Change with an empty Resource ahead of the
+// changes made since, which a replica applies by deleting its collection.
+func (d *Driver) Changes(collection string, afterSeq uint64) ([]Change, uint64, error) {
+	leave, err := d.enter()
+	if err != nil {
+		return nil, afterSeq, err
+	}
+	defer leave()
+
+	if collection == "" {
+		return nil, afterSeq, fmt.Errorf("missing collection - unable to read")
+	}
+	if !d.opts.TrackChanges {
+		return nil, afterSeq, fmt.Errorf("missing changelog - Options.TrackChanges is not set")
+	}
+
+	mutex := d.getOrCreateMutex(collection)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	path := d.changelogFile(collection)
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return []Change{}, afterSeq, nil
+	}
+	if err != nil {
+		return nil, afterSeq, err
+	}
+	defer f.Close()
+
+	latest := make(map[string]changeEntry)
+	watermark := afterSeq
+	var drop *changeEntry
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var entry changeEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, afterSeq, fmt.Errorf("unable to read changelog %v: %w", path, err)
+		}
+		if entry.Seq <= afterSeq {
+			continue
+		}
+		if entry.Resource == "" {
+			// Everything before the drop is gone with the collection.
+			drop = &entry
+			latest = make(map[string]changeEntry)
+		} else {
+			latest[entry.Resource] = entry
+		}
+		if entry.Seq > watermark {
+			watermark = entry.Seq
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, afterSeq, err
+	}
+
+	changes := make([]Change, 0, len(latest)+1)
+	if drop != nil {
+		changes = append(changes, Change{Seq: drop.Seq, Deleted: true})
+	}
+	for _, entry := range latest {
+		change := Change{Seq: entry.Seq, Resource: entry.Resource, Deleted: entry.Deleted}
+		if !change.Deleted {
+			b, err := d.readRecord(collection, entry.Resource)
+			switch {
+			case err == nil:
+				change.Raw = b
+			case errors.Is(err, ErrNotFound):
+				change.Deleted = true
+			default:
+				return nil, afterSeq, err
+			}
+		}
+		changes = append(changes, change)
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Seq < changes[j].Seq
+	})
+	return changes, watermark, nil
+}
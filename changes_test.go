@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// changeSummary renders changes as "resource@seq", with a "-" for deletes.
+func changeSummary(changes []Change) string {
+	var parts []string
+	for _, change := range changes {
+		part := fmt.Sprintf("%v@%d", change.Resource, change.Seq)
+		if change.Deleted {
+			part = "-" + part
+		}
+		parts = append(parts, part)
+	}
+	return fmt.Sprint(parts)
+}
+
+func TestChangesCursor(t *testing.T) {
+	d := newTestDriver(t, WithTrackChanges(true))
+	mustWrite(t, d, "users", map[string]interface{}{"Arnab": demoUsers()[0]})
+	mustWrite(t, d, "users", map[string]interface{}{"John": demoUsers()[1]})
+
+	changes, cursor, err := d.Changes("users", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changeSummary(changes) != "[Arnab@1 John@2]" || cursor != 2 {
+		t.Fatalf("Changes(0) = %v, %d", changeSummary(changes), cursor)
+	}
+	var user User
+	if err := json.Unmarshal(changes[0].Raw, &user); err != nil || user.Name != "Arnab" {
+		t.Fatalf("Raw of Arnab is %q", changes[0].Raw)
+	}
+
+	mustWrite(t, d, "users", map[string]interface{}{"Arnab": demoUsers()[0]})
+	if err := d.Delete("users", "John"); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, next, err := d.Changes("users", cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changeSummary(changes) != "[Arnab@3 -John@4]" || next != 4 {
+		t.Fatalf("Changes(%d) = %v, %d", cursor, changeSummary(changes), next)
+	}
+
+	// Nothing new leaves the cursor where it is.
+	changes, again, err := d.Changes("users", next)
+	if err != nil || len(changes) != 0 || again != next {
+		t.Fatalf("Changes(%d) with nothing new = %v, %d, %v", next, changeSummary(changes), again, err)
+	}
+}
+
+func TestChangesAfterDrop(t *testing.T) {
+	d := newTestDriver(t, WithTrackChanges(true))
+	writeDemoUsers(t, d)
+	_, cursor, err := d.Changes("users", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Delete("users", ""); err != nil {
+		t.Fatal(err)
+	}
+	mustWrite(t, d, "users", map[string]interface{}{"Zed": User{Name: "Zed"}})
+
+	// The sequence keeps counting across the drop.
+	changes, next, err := d.Changes("users", cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("[-@%d Zed@%d]", cursor+1, cursor+2)
+	if changeSummary(changes) != want || next != cursor+2 {
+		t.Fatalf("Changes(%d) = %v, %d; want %v", cursor, changeSummary(changes), next, want)
+	}
+}
+
+func TestChangesRequiresTracking(t *testing.T) {
+	d := newTestDriver(t)
+	if _, _, err := d.Changes("users", 0); err == nil {
+		t.Fatal("Changes worked without TrackChanges")
+	}
+}
+
+func TestChangesAcrossRename(t *testing.T) {
+	d := newTestDriver(t, WithTrackChanges(true))
+	writeDemoUsers(t, d)
+	_, cursor, err := d.Changes("users", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.RenameCollection("users", "people"); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, _, err := d.Changes("users", cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("[-@%d]", cursor+1); changeSummary(changes) != want {
+		t.Fatalf("users after renaming away = %v, want %v", changeSummary(changes), want)
+	}
+
+	people, _, err := d.Changes("people", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(people) != len(demoUsers()) {
+		t.Fatalf("people has %d changes, want every user", len(people))
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+// CreateCollection creates an empty collection, or returns ErrExists if it
+// is already there.
+func (d *Driver) CreateCollection(collection string) error {
+	return d.createCollection(collection, true)
+}
+
+// EnsureCollection creates an empty collection unless it is already there.
+func (d *Driver) EnsureCollection(collection string) error {
+	return d.createCollection(collection, false)
+}
+
+func (d *Driver) createCollection(collection string, exclusive bool) error {
+	leave, err := d.enter()
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if collection == "" {
+		return fmt.Errorf("missing collection - unable to create")
+	}
+
+	mutex := d.getOrCreateMutex(collection)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if d.collectionExists(collection) {
+		if exclusive {
+			return fmt.Errorf("%w: collection %v", ErrExists, collection)
+		}
+		return nil
+	}
+
+	if d.opts.Layout == SingleFile {
+		return d.writeCollectionFile(collection, map[string]json.RawMessage{})
+	}
+
+	path := filepath.Join(*d.dir, collection)
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return diskError(err)
+	}
+	return nil
+}
+
+// ReadCollection decodes a whole collection into out as one JSON object
+// mapping resource names to records, e.g. into a map[string]User. Under the
+// SingleFile layout that is the collection file itself, read once, unless
+// Options.EncryptFields lists fields of it; otherwise the object is
+// assembled from the individual records.
+func (d *Driver) ReadCollection(collection string, out interface{}) error {
+	if collection == "" {
+		return fmt.Errorf("missing collection - unable to read")
+	}
+	if rv := reflect.ValueOf(out); rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("%w: got %T", ErrNotPointer, out)
+	}
+
+	var b []byte
+
+	err := d.withTimeout(func() error {
+		var err error
+		if d.opts.Layout == SingleFile && !d.encrypts(collection) {
+			b, err = d.readFile(d.collectionFile(collection))
+			return err
+		}
+		b, err = d.assembleCollection(collection)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := d.decode(b, out); err != nil {
+		return fmt.Errorf("unable to decode collection %v: %w", collection, err)
+	}
+	return nil
+}
+
+func (d *Driver) assembleCollection(collection string) ([]byte, error) {
+	resources, read, err := d.records(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make(map[string]json.RawMessage, len(resources))
+	for _, resource := range resources {
+		b, err := read(resource)
+		if err != nil {
+			return nil, err
+		}
+
+		var raw json.RawMessage
+		if err := json.Unmarshal(b, &raw); err != nil {
+			return nil, decodeError(collection, resource, b, err)
+		}
+		records[resource] = raw
+	}
+	return json.Marshal(records)
+}
+
+// RenameCollection renames a whole collection, holding both collection
+// locks. It returns ErrCollectionNotFound if oldName does not exist and
+// ErrExists if newName already does. Under the PerFile layout it is a single
+// directory rename, so readers see either name but never a partial
+// collection.
+func (d *Driver) RenameCollection(oldName, newName string) error {
+	leave, err := d.enter()
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if oldName == "" || newName == "" {
+		return fmt.Errorf("missing collection - unable to rename")
+	}
+	if oldName == newName {
+		return nil
+	}
+
+	unlock := d.lockCollections(oldName, newName)
+	defer unlock()
+
+	// Records staged under newName make it exist, so they are flushed too
+	// rather than landing in the renamed collection later.
+	for _, collection := range []string{oldName, newName} {
+		if err := d.flushLocked(collection); err != nil {
+			return err
+		}
+	}
+
+	if !d.collectionExists(oldName) {
+		return fmt.Errorf("%w: %v", ErrCollectionNotFound, oldName)
+	}
+	if d.collectionExists(newName) {
+		return fmt.Errorf("%w: collection %v", ErrExists, newName)
+	}
+
+	oldDir, newDir := filepath.Join(*d.dir, oldName), filepath.Join(*d.dir, newName)
+
+	if d.opts.Layout == SingleFile {
+		if err := os.Rename(d.collectionFile(oldName), d.collectionFile(newName)); err != nil {
+			return diskError(err)
+		}
+		// The directory only holds sidecars, if anything.
+		if err := os.Rename(oldDir, newDir); err != nil && !os.IsNotExist(err) {
+			return diskError(err)
+		}
+	} else if err := os.Rename(oldDir, newDir); err != nil {
+		return diskError(err)
+	}
+
+	d.forget(oldName, "")
+
+	d.mutex.Lock()
+	delete(d.known, oldName)
+	d.known[newName] = true
+	d.mutex.Unlock()
+
+	if !d.opts.TrackChanges {
+		return nil
+	}
+	if err := d.logChange(oldName, true, ""); err != nil {
+		return err
+	}
+	resources, err := d.storedResources(newName)
+	if err != nil {
+		return err
+	}
+	return d.logChange(newName, false, resources...)
+}
+
+// CopyCollection duplicates every record of src, along with its metadata,
+// into a new collection dst, holding both collection locks. It returns
+// ErrCollectionNotFound if src does not exist and ErrExists if dst already
+// does. Files are copied as they are stored, so compressed records stay
+// compressed. The copy is assembled under a hidden name and renamed into
+// place, so dst never appears half-copied.
+func (d *Driver) CopyCollection(src, dst string) error {
+	leave, err := d.enter()
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if src == "" || dst == "" {
+		return fmt.Errorf("missing collection - unable to copy")
+	}
+
+	unlock := d.lockCollections(src, dst)
+	defer unlock()
+
+	for _, collection := range []string{src, dst} {
+		if err := d.flushLocked(collection); err != nil {
+			return err
+		}
+	}
+
+	if !d.collectionExists(src) {
+		return fmt.Errorf("%w: %v", ErrCollectionNotFound, src)
+	}
+	if d.collectionExists(dst) {
+		return fmt.Errorf("%w: collection %v", ErrExists, dst)
+	}
+
+	srcDir, dstDir := filepath.Join(*d.dir, src), filepath.Join(*d.dir, dst)
+	if err := os.MkdirAll(filepath.Dir(dstDir), 0755); err != nil {
+		return diskError(err)
+	}
+
+	if d.opts.Layout == SingleFile {
+		// The directory only holds sidecars, if anything.
+		if _, err := os.Stat(srcDir); err == nil {
+			if err := d.copyCollectionDir(srcDir, dstDir); err != nil {
+				return err
+			}
+		}
+
+		tmpPath := d.collectionFile(dst) + d.opts.TempSuffix
+		err := copyFile(d.collectionFile(src), tmpPath)
+		if err == nil {
+			err = replaceFile(tmpPath, d.collectionFile(dst))
+		}
+		if err != nil {
+			os.Remove(tmpPath)
+			os.RemoveAll(dstDir)
+			return err
+		}
+	} else if err := d.copyCollectionDir(srcDir, dstDir); err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	d.known[dst] = true
+	d.mutex.Unlock()
+
+	resources, err := d.storedResources(dst)
+	if err != nil {
+		return err
+	}
+	return d.logChange(dst, false, resources...)
+}
+
+// copyCollectionDir copies the directory of a collection to dstDir through a
+// hidden directory beside it.
+func (d *Driver) copyCollectionDir(srcDir, dstDir string) error {
+	tmpDir := filepath.Join(filepath.Dir(dstDir), "."+filepath.Base(dstDir)+d.opts.TempSuffix)
+
+	// Anything already there is left from an interrupted copy.
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return err
+	}
+
+	err := d.copyDir(srcDir, tmpDir)
+	if err == nil {
+		err = diskError(os.Rename(tmpDir, dstDir))
+	}
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return err
+	}
+	return nil
+}
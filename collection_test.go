@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateCollection(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+
+	if err := d.CreateCollection("users"); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "users")); err != nil || !fi.IsDir() {
+		t.Fatalf("collection directory not created: %v", err)
+	}
+	if err := d.CreateCollection("users"); !errors.Is(err, ErrExists) {
+		t.Fatalf("creating it again returned %v, want ErrExists", err)
+	}
+}
+
+func TestEnsureCollection(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+	writeDemoUsers(t, d)
+
+	if err := d.EnsureCollection("users"); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := d.Keys("users"); len(keys) != len(demoUsers()) {
+		t.Fatalf("EnsureCollection changed an existing collection to %v", keys)
+	}
+	if err := d.EnsureCollection("events"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "events")); err != nil {
+		t.Fatalf("collection directory not created: %v", err)
+	}
+}
+
+func TestReadCollection(t *testing.T) {
+	for name, layout := range layouts {
+		t.Run(name, func(t *testing.T) {
+			d := newTestDriver(t, WithLayout(layout))
+			writeDemoUsers(t, d)
+
+			var users map[string]User
+			if err := d.ReadCollection("users", &users); err != nil {
+				t.Fatal(err)
+			}
+			if len(users) != len(demoUsers()) {
+				t.Fatalf("ReadCollection returned %d users", len(users))
+			}
+			for _, user := range demoUsers() {
+				if users[user.Name] != user {
+					t.Errorf("users[%v] = %+v, want %+v", user.Name, users[user.Name], user)
+				}
+			}
+		})
+	}
+}
+
+func TestRenameCollection(t *testing.T) {
+	for name, layout := range layouts {
+		t.Run(name, func(t *testing.T) {
+			d := newTestDriver(t, WithLayout(layout))
+			writeDemoUsers(t, d)
+
+			if err := d.RenameCollection("users", "people"); err != nil {
+				t.Fatal(err)
+			}
+
+			var user User
+			if err := d.Read("people", "Arnab", &user); err != nil || user.Name != "Arnab" {
+				t.Fatalf("Read under the new name returned %+v, %v", user, err)
+			}
+			if err := d.Read("users", "Arnab", &user); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Read under the old name returned %v, want ErrNotFound", err)
+			}
+
+			if err := d.RenameCollection("users", "staff"); !errors.Is(err, ErrCollectionNotFound) {
+				t.Fatalf("renaming a missing collection returned %v, want ErrCollectionNotFound", err)
+			}
+			mustWrite(t, d, "staff", map[string]interface{}{"Zed": User{Name: "Zed"}})
+			if err := d.RenameCollection("people", "staff"); !errors.Is(err, ErrExists) {
+				t.Fatalf("renaming onto an existing collection returned %v, want ErrExists", err)
+			}
+		})
+	}
+}
+
+func TestRenameCollectionBuffered(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir, WithBufferWrites(100, time.Hour))
+	writeDemoUsers(t, d)
+
+	mustWrite(t, d, "staff", map[string]interface{}{"Zed": User{Name: "Zed"}})
+	if err := d.RenameCollection("users", "staff"); !errors.Is(err, ErrExists) {
+		t.Fatalf("renaming onto a collection with staged records returned %v, want ErrExists", err)
+	}
+
+	if err := d.RenameCollection("users", "people"); err != nil {
+		t.Fatal(err)
+	}
+	other := openTestDriver(t, dir)
+	var user User
+	if err := other.Read("people", "Arnab", &user); err != nil || user.Name != "Arnab" {
+		t.Fatalf("staged record under the new name returned %+v, %v", user, err)
+	}
+	if err := other.Read("staff", "Zed", &user); err != nil || user.Name != "Zed" {
+		t.Fatalf("record staged under the target returned %+v, %v", user, err)
+	}
+}
+
+func TestCopyCollection(t *testing.T) {
+	for name, layout := range layouts {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			d := openTestDriver(t, dir, WithLayout(layout), WithCompress(layout == PerFile))
+			writeDemoUsers(t, d)
+
+			if err := d.CopyCollection("users", "staff"); err != nil {
+				t.Fatal(err)
+			}
+
+			src, err := d.ReadAll("users")
+			if err != nil {
+				t.Fatal(err)
+			}
+			dst, err := d.ReadAll("staff")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(src, "\n") != strings.Join(dst, "\n") {
+				t.Fatalf("copy holds\n%v\nwant\n%v", dst, src)
+			}
+
+			if layout == PerFile {
+				b, err := os.ReadFile(filepath.Join(dir, "staff", "Arnab.json.gz"))
+				if err != nil || !isGzip(b) {
+					t.Fatalf("copied record is not stored compressed: %v", err)
+				}
+			}
+
+			// The copy is independent of its source.
+			if err := d.Delete("users", "Arnab"); err != nil {
+				t.Fatal(err)
+			}
+			var user User
+			if err := d.Read("staff", "Arnab", &user); err != nil || user.Name != "Arnab" {
+				t.Fatalf("Read from the copy returned %+v, %v", user, err)
+			}
+
+			if err := d.CopyCollection("users", "staff"); !errors.Is(err, ErrExists) {
+				t.Fatalf("CopyCollection onto an existing collection returned %v, want ErrExists", err)
+			}
+			if err := d.CopyCollection("missing", "other"); !errors.Is(err, ErrCollectionNotFound) {
+				t.Fatalf("CopyCollection of a missing collection returned %v, want ErrCollectionNotFound", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CompactReport describes what Compact cleaned up.
+type CompactReport struct {
+	TempFilesRemoved  int
+	RecordsCompressed int
+	BytesReclaimed    int64
+}
+
+// Compact removes the temporary files interrupted writes left behind in
+// collection, and with Options.Compress set rewrites plain records with the
+// configured codec. It holds the collection lock, so in-flight writes are
+// never disturbed.
+func (d *Driver) Compact(collection string) (CompactReport, error) {
+	var report CompactReport
+
+	leave, err := d.enter()
+	if err != nil {
+		return report, err
+	}
+	defer leave()
+
+	if d.opts.ReadOnly {
+		return report, ErrReadOnly
+	}
+	if collection == "" {
+		return report, fmt.Errorf("missing collection - unable to compact")
+	}
+
+	mutex := d.getOrCreateMutex(collection)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	temps, err := d.tempFiles(collection)
+	if err != nil {
+		return report, err
+	}
+
+	for _, temp := range temps {
+		if d.opts.DryRun {
+			d.log.Info("Would delete '%s'\n", temp.path)
+		} else if err := os.Remove(temp.path); err != nil {
+			return report, err
+		}
+
+		report.TempFilesRemoved++
+		report.BytesReclaimed += temp.size
+	}
+
+	if d.opts.Compress {
+		return report, d.compressRecords(collection, &report)
+	}
+	return report, nil
+}
+
+// compressRecords rewrites the plain records of a PerFile collection through
+// writeRecord, so they are stored with the configured codec. The caller must
+// hold the collection lock.
+func (d *Driver) compressRecords(collection string, report *CompactReport) error {
+	if !d.opts.DryRun {
+		// A staged record is newer than the stored one writeRecord would
+		// otherwise discard it for.
+		if err := d.flushLocked(collection); err != nil {
+			return err
+		}
+	}
+
+	resources, err := d.storedResources(collection)
+	if err != nil {
+		return err
+	}
+
+	for _, resource := range resources {
+		path, fi, err := d.stat(collection, resource)
+		if err != nil {
+			return err
+		}
+		if d.codecFor(path) != nil {
+			continue
+		}
+
+		b, err := d.readFile(path)
+		if err != nil {
+			return err
+		}
+		compressed, err := compressBytes(d.opts.Compressor, b)
+		if err != nil {
+			return err
+		}
+
+		if d.opts.DryRun {
+			d.log.Info("Would compress '%s'\n", path)
+		} else if _, err := d.writeRecord(collection, resource, b); err != nil {
+			return err
+		}
+
+		report.RecordsCompressed++
+		report.BytesReclaimed += fi.Size() - int64(len(compressed))
+	}
+	return nil
+}
+
+type tempFile struct {
+	path string
+	size int64
+}
+
+// tempFiles finds the temporary files left behind in collection by writes
+// that never reached their final rename.
+func (d *Driver) tempFiles(collection string) ([]tempFile, error) {
+	if d.opts.Layout == SingleFile {
+		path := d.collectionFile(collection) + d.opts.TempSuffix
+
+		fi, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []tempFile{{path: path, size: fi.Size()}}, nil
+	}
+
+	dir := filepath.Join(*d.dir, collection)
+
+	var temps []tempFile
+
+	if d.opts.PathFor != nil {
+		err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.isTempFile(fi) {
+				temps = append(temps, tempFile{path: path, size: fi.Size()})
+			}
+			return nil
+		})
+		return temps, err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		if d.isTempFile(file) {
+			temps = append(temps, tempFile{path: filepath.Join(dir, file.Name()), size: file.Size()})
+		}
+	}
+	return temps, nil
+}
+
+func (d *Driver) isTempFile(fi os.FileInfo) bool {
+	return fi.Mode().IsRegular() && strings.HasSuffix(fi.Name(), d.opts.TempSuffix)
+}
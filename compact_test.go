@@ -0,0 +1,144 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompactRemovesTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+	writeDemoUsers(t, d)
+
+	stray := filepath.Join(dir, "users", "Arnab.json.tmp")
+	if err := os.WriteFile(stray, []byte(`{"Name": "Arn`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := d.Compact("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.TempFilesRemoved != 1 || report.BytesReclaimed != 13 {
+		t.Fatalf("Compact reported %+v, want 1 file and 13 bytes", report)
+	}
+	if _, err := os.Stat(stray); !os.IsNotExist(err) {
+		t.Fatalf("stray temp file still there: %v", err)
+	}
+
+	records, err := d.ReadAll("users")
+	if err != nil || len(records) != len(demoUsers()) {
+		t.Fatalf("ReadAll after Compact returned %d records, %v", len(records), err)
+	}
+}
+
+func TestCompactDryRun(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+	writeDemoUsers(t, d)
+
+	stray := filepath.Join(dir, "users", "Arnab.json.tmp")
+	if err := os.WriteFile(stray, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := d.With(WithDryRun(true)).Compact("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.TempFilesRemoved != 1 {
+		t.Fatalf("dry run reported %+v, want 1 file", report)
+	}
+	if _, err := os.Stat(stray); err != nil {
+		t.Fatalf("dry run removed the temp file: %v", err)
+	}
+}
+
+func TestCompactCompressesPlainRecords(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+	writeDemoUsers(t, d)
+
+	plain := filepath.Join(dir, "users", "Arnab.json")
+	before, err := os.Stat(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compressed := d.With(WithCompress(true))
+	dry, err := compressed.With(WithDryRun(true)).Compact("users")
+	if err != nil || dry.RecordsCompressed != len(demoUsers()) {
+		t.Fatalf("dry run reported %+v, %v", dry, err)
+	}
+	if _, err := os.Stat(plain); err != nil {
+		t.Fatalf("dry run rewrote the plain record: %v", err)
+	}
+
+	report, err := compressed.Compact("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != dry {
+		t.Fatalf("Compact reported %+v, dry run %+v", report, dry)
+	}
+	if _, err := os.Stat(plain); !os.IsNotExist(err) {
+		t.Fatalf("plain record still there: %v", err)
+	}
+	after, err := os.Stat(plain + ".gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.BytesReclaimed <= 0 || after.Size() >= before.Size() {
+		t.Fatalf("Compact reported %+v; %d bytes became %d", report, before.Size(), after.Size())
+	}
+
+	for _, want := range demoUsers() {
+		var user User
+		if err := d.Read("users", want.Name, &user); err != nil || user != want {
+			t.Errorf("Read(%v) = %+v, %v; want %+v", want.Name, user, err, want)
+		}
+	}
+
+	if again, err := compressed.Compact("users"); err != nil || again.RecordsCompressed != 0 {
+		t.Fatalf("second Compact reported %+v, %v", again, err)
+	}
+}
+
+func TestCustomTempSuffix(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir, WithTempSuffix(".partial"))
+	writeDemoUsers(t, d)
+
+	// With another suffix, .tmp is an ordinary resource name.
+	if err := d.Write("users", "report.tmp", User{Name: "report"}); err != nil {
+		t.Fatal(err)
+	}
+	stray := filepath.Join(dir, "users", "Zed.json.partial")
+	if err := os.WriteFile(stray, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := d.Keys("users")
+	if err != nil || len(keys) != len(demoUsers())+1 {
+		t.Fatalf("Keys = %v, %v", keys, err)
+	}
+	var user User
+	if err := d.Read("users", "report.tmp", &user); err != nil || user.Name != "report" {
+		t.Fatalf("Read of report.tmp returned %+v, %v", user, err)
+	}
+	if records, err := d.ReadAll("users"); err != nil || len(records) != len(keys) {
+		t.Fatalf("ReadAll returned %d records, %v", len(records), err)
+	}
+
+	report, err := d.Compact("users")
+	if err != nil || report.TempFilesRemoved != 1 {
+		t.Fatalf("Compact reported %+v, %v", report, err)
+	}
+	if _, err := os.Stat(stray); !os.IsNotExist(err) {
+		t.Fatalf("stray temp file still there: %v", err)
+	}
+	if err := d.Read("users", "report.tmp", &user); err != nil {
+		t.Fatalf("Compact removed report.tmp: %v", err)
+	}
+}
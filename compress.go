@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// Compressor is a compression codec for Options.Compress. Records it
+// compresses are stored as <resource>.json followed by Ext, such as ".zst".
+// A zstd codec, for instance, can wrap github.com/klauspost/compress/zstd:
+//
+//	type zstdCompressor struct{}
+//
+//	func (zstdCompressor) Ext() string { return ".zst" }
+//
+//	func (zstdCompressor) Compress(w io.Writer) io.WriteCloser {
+//		enc, _ := zstd.NewWriter(w)
+//		return enc
+//	}
+//
+//	func (zstdCompressor) Decompress(r io.Reader) (io.Reader, error) {
+//		return zstd.NewReader(r)
+//	}
+type Compressor interface {
+	Ext() string
+	Compress(w io.Writer) io.WriteCloser
+	Decompress(r io.Reader) (io.Reader, error)
+}
+
+// gzipCompressor is the default Compressor.
+type gzipCompressor struct{}
+
+func (gzipCompressor) Ext() string { return ".gz" }
+
+func (gzipCompressor) Compress(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }
+
+func (gzipCompressor) Decompress(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }
+
+// compressors lists the codecs records may be stored with: the configured
+// one, and gzip, so records written before switching codecs stay readable.
+// A configured codec using gzip's extension replaces gzip, or both would
+// claim the same files.
+func (d *Driver) compressors() []Compressor {
+	if d.opts.Compressor.Ext() == (gzipCompressor{}).Ext() {
+		return []Compressor{d.opts.Compressor}
+	}
+	return []Compressor{d.opts.Compressor, gzipCompressor{}}
+}
+
+// codecFor returns the codec a record stored at path is compressed with, or
+// nil for a plain record.
+func (d *Driver) codecFor(path string) Compressor {
+	for _, c := range d.compressors() {
+		if strings.HasSuffix(path, jsonExt+c.Ext()) {
+			return c
+		}
+	}
+	return nil
+}
+
+func compressBytes(c Compressor, b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w := c.Compress(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompressBytes(c Compressor, b []byte) ([]byte, error) {
+	r, err := c.Decompress(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	if closer, ok := r.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	return ioutil.ReadAll(r)
+}
+
+// isGzip reports whether b starts with the gzip magic bytes, which no JSON
+// document can.
+func isGzip(b []byte) bool {
+	return len(b) >= 2 && b[0] == 0x1f && b[1] == 0x8b
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompressedRecords(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir, WithCompress(true))
+	writeDemoUsers(t, d)
+
+	path := filepath.Join(dir, "users", "Arnab.json.gz")
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isGzip(b) {
+		t.Fatalf("%v is not gzip", path)
+	}
+
+	var user User
+	if err := d.Read("users", "Arnab", &user); err != nil || user.Name != "Arnab" {
+		t.Fatalf("Read returned %+v, %v", user, err)
+	}
+
+	// A driver that does not compress still finds them.
+	plain := openTestDriver(t, dir)
+	if err := plain.Read("users", "Harry", &user); err != nil || user.Name != "Harry" {
+		t.Fatalf("uncompressed driver Read returned %+v, %v", user, err)
+	}
+	if err := plain.Delete("users", "Harry"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "users", "Harry.json.gz")); !os.IsNotExist(err) {
+		t.Fatalf("Delete left the compressed record: %v", err)
+	}
+	if err := d.Read("users", "Harry", &user); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Read after Delete returned %v, want ErrNotFound", err)
+	}
+
+	// Rewriting a record under the other setting converts it.
+	if err := plain.Write("users", "Arnab", demoUsers()[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("rewriting uncompressed kept %v: %v", path, err)
+	}
+	if records, err := d.ReadAll("users"); err != nil || len(records) != len(demoUsers())-1 {
+		t.Fatalf("ReadAll over mixed records returned %d, %v", len(records), err)
+	}
+}
+
+func TestReadDetectsGzipContent(t *testing.T) {
+	dir := t.TempDir()
+	writeDemoUsers(t, openTestDriver(t, dir, WithCompress(true)))
+
+	// A gzip record renamed to plain .json is still decompressed.
+	gz := filepath.Join(dir, "users", "Arnab.json.gz")
+	if err := os.Rename(gz, filepath.Join(dir, "users", "Arnab.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	d := openTestDriver(t, dir)
+	for _, name := range []string{"Arnab", "John"} {
+		var user User
+		if err := d.Read("users", name, &user); err != nil || user.Name != name {
+			t.Errorf("Read(%v) with compression off returned %+v, %v", name, user, err)
+		}
+	}
+	records, err := d.ReadAll("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if users := decodeAll[User](t, records); len(users) != len(demoUsers()) {
+		t.Fatalf("ReadAll with compression off returned %d users", len(users))
+	}
+}
+
+// xorCompressor is a toy codec that flips every bit of a record, so what it
+// stores is neither JSON nor gzip.
+type xorCompressor struct{ ext string }
+
+func (c xorCompressor) Ext() string { return c.ext }
+
+func (xorCompressor) Compress(w io.Writer) io.WriteCloser { return nopWriteCloser{xorWriter{w}} }
+
+func (xorCompressor) Decompress(r io.Reader) (io.Reader, error) { return xorReader{r}, nil }
+
+type xorWriter struct{ w io.Writer }
+
+func (x xorWriter) Write(p []byte) (int, error) {
+	flipped := make([]byte, len(p))
+	for i, b := range p {
+		flipped[i] = ^b
+	}
+	return x.w.Write(flipped)
+}
+
+type xorReader struct{ r io.Reader }
+
+func (x xorReader) Read(p []byte) (int, error) {
+	n, err := x.r.Read(p)
+	for i := range p[:n] {
+		p[i] = ^p[i]
+	}
+	return n, err
+}
+
+func TestCustomCompressor(t *testing.T) {
+	dir := t.TempDir()
+	writeDemoUsers(t, openTestDriver(t, dir, WithCompress(true)))
+
+	d := openTestDriver(t, dir, WithCompressor(xorCompressor{".xor"}))
+	if err := d.Write("users", "Arnab", User{Name: "Arnab", Company: "Acme"}); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "users", "Arnab.json.xor"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if json.Valid(b) || isGzip(b) {
+		t.Fatalf("custom codec stored %q", b)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "users", "Arnab.json.gz")); !os.IsNotExist(err) {
+		t.Fatalf("rewriting with the custom codec kept the gzip record: %v", err)
+	}
+
+	var user User
+	if err := d.Read("users", "Arnab", &user); err != nil || user.Company != "Acme" {
+		t.Fatalf("Read of a custom codec record returned %+v, %v", user, err)
+	}
+	// Records gzipped before the switch still read.
+	if err := d.Read("users", "John", &user); err != nil || user.Name != "John" {
+		t.Fatalf("Read of a gzip record returned %+v, %v", user, err)
+	}
+}
+
+func TestCustomCompressorOnGzipExtension(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir, WithCompressor(xorCompressor{".gz"}))
+	writeDemoUsers(t, d)
+
+	// Rewriting a record must not remove it as a stale copy under the
+	// other codec claiming the same extension.
+	for _, company := range []string{"Acme", "Initech"} {
+		if err := d.Write("users", "Arnab", User{Name: "Arnab", Company: company}); err != nil {
+			t.Fatal(err)
+		}
+		var user User
+		if err := d.Read("users", "Arnab", &user); err != nil || user.Company != company {
+			t.Fatalf("Read after rewriting returned %+v, %v", user, err)
+		}
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "users", "Arnab.json.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if json.Valid(b) || isGzip(b) {
+		t.Fatalf("custom codec stored %q", b)
+	}
+	if records, err := d.ReadAll("users"); err != nil || len(records) != len(demoUsers()) {
+		t.Fatalf("ReadAll returned %d records, %v", len(records), err)
+	}
+}
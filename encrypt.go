@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// encryptedPrefix marks a field value sealed by Options.EncryptFields.
+const encryptedPrefix = "enc:"
+
+// encrypts reports whether Options.EncryptFields lists fields of collection.
+func (d *Driver) encrypts(collection string) bool {
+	return len(d.opts.EncryptFields[collection]) > 0
+}
+
+// sealFields encrypts the fields of a record listed in Options.EncryptFields
+// for collection. Each becomes a string holding encryptedPrefix and the
+// base64 of its nonce and AES-GCM ciphertext. Fields that are missing or
+// not strings, and records that are not objects, are left alone.
+func (d *Driver) sealFields(collection, resource string, b []byte) ([]byte, error) {
+	return d.mapFields(collection, resource, b, "encrypt", func(aead cipher.AEAD, field, value string) (string, error) {
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		sealed := aead.Seal(nonce, nonce, []byte(value), sealedData(collection, resource, field))
+		return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+	})
+}
+
+// openFields reverses sealFields. A listed field that was never sealed,
+// e.g. one written before it was listed, is returned as stored.
+func (d *Driver) openFields(collection, resource string, b []byte) ([]byte, error) {
+	return d.mapFields(collection, resource, b, "decrypt", func(aead cipher.AEAD, field, value string) (string, error) {
+		if !strings.HasPrefix(value, encryptedPrefix) {
+			return value, nil
+		}
+		sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+		if err != nil {
+			return "", err
+		}
+		if len(sealed) < aead.NonceSize() {
+			return "", fmt.Errorf("ciphertext too short")
+		}
+		nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		plain, err := aead.Open(nil, nonce, sealed, sealedData(collection, resource, field))
+		if err != nil {
+			return "", err
+		}
+		return string(plain), nil
+	})
+}
+
+// sealedData is the additional data a field is sealed with. It binds the
+// ciphertext to where it is stored, so a value copied into another record
+// or field does not open.
+func sealedData(collection, resource, field string) []byte {
+	return []byte(collection + "/" + resource + "/" + field)
+}
+
+// mapFields replaces each listed string field of a record with what fn
+// returns for it. Only those values are rewritten; the rest of the record,
+// key order included, keeps its bytes. action names fn in errors.
+func (d *Driver) mapFields(collection, resource string, b []byte, action string, fn func(aead cipher.AEAD, field, value string) (string, error)) ([]byte, error) {
+	fields := d.opts.EncryptFields[collection]
+	if len(fields) == 0 {
+		return b, nil
+	}
+
+	values, ok := fieldValues(b, fields)
+	if !ok || len(values) == 0 {
+		return b, nil
+	}
+
+	block, err := aes.NewCipher(d.opts.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	var last int64
+	for _, v := range values {
+		value, err := fn(aead, v.field, v.value)
+		if err != nil {
+			return nil, fmt.Errorf("unable to %v %v/%v field %v: %w", action, collection, resource, v.field, err)
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+
+		out.Write(b[last:v.start])
+		out.Write(raw)
+		last = v.end
+	}
+	out.Write(b[last:])
+	return out.Bytes(), nil
+}
+
+// fieldValue is a top-level string field of a record and where its value
+// sits in the record's bytes.
+type fieldValue struct {
+	field, value string
+	start, end   int64
+}
+
+// fieldValues finds the listed top-level string fields of the object b, in
+// the order they appear. It reports false if b is not a JSON object.
+func fieldValues(b []byte, fields []string) ([]fieldValue, bool) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, false
+	}
+
+	listed := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		listed[field] = true
+	}
+
+	var values []fieldValue
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, false
+		}
+		key, _ := tok.(string)
+
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, false
+		}
+		end := dec.InputOffset()
+
+		var value string
+		if !listed[key] || json.Unmarshal(raw, &value) != nil {
+			continue
+		}
+		values = append(values, fieldValue{field: key, value: value, start: end - int64(len(raw)), end: end})
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, false
+	}
+	return values, true
+}
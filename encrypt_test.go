@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryptFields(t *testing.T) {
+	dir := t.TempDir()
+	key := []byte("0123456789abcdef0123456789abcdef")
+	d := openTestDriver(t, dir, WithEncryptionKey(key), WithEncryptFields("users", "Contact"))
+	writeDemoUsers(t, d)
+	mustWrite(t, d, "staff", map[string]interface{}{"Arnab": demoUsers()[0]})
+
+	b, err := os.ReadFile(filepath.Join(dir, "users", "Arnab.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored map[string]interface{}
+	if err := json.Unmarshal(b, &stored); err != nil {
+		t.Fatal(err)
+	}
+	contact, _ := stored["Contact"].(string)
+	if !strings.HasPrefix(contact, encryptedPrefix) || bytes.Contains(b, []byte("322444566")) {
+		t.Fatalf("Contact stored as %q", contact)
+	}
+	if stored["Name"] != "Arnab" || stored["Company"] != "DAPL" {
+		t.Fatalf("fields not listed were not stored as plaintext: %s", b)
+	}
+
+	var user User
+	if err := d.Read("users", "Arnab", &user); err != nil || user.Contact != "322444566" {
+		t.Fatalf("Read returned %+v, %v", user, err)
+	}
+
+	// Collections not listed are stored as they are.
+	if b, err := os.ReadFile(filepath.Join(dir, "staff", "Arnab.json")); err != nil || !bytes.Contains(b, []byte("322444566")) {
+		t.Fatalf("unlisted collection stored %s, %v", b, err)
+	}
+
+	// Another key cannot open the field.
+	other := openTestDriver(t, dir, WithEncryptionKey([]byte("fedcba9876543210fedcba9876543210")), WithEncryptFields("users", "Contact"))
+	if err := other.Read("users", "Arnab", &user); err == nil {
+		t.Fatal("Read with the wrong key succeeded")
+	}
+}
+
+func TestEncryptFieldsWrittenBefore(t *testing.T) {
+	dir := t.TempDir()
+	writeDemoUsers(t, openTestDriver(t, dir))
+
+	// Records written before the field was listed read as stored, and are
+	// sealed once rewritten.
+	d := openTestDriver(t, dir, WithEncryptionKey([]byte("0123456789abcdef")), WithEncryptFields("users", "Contact"))
+	var user User
+	if err := d.Read("users", "John", &user); err != nil || user.Contact != "322444564" {
+		t.Fatalf("Read of a record written before returned %+v, %v", user, err)
+	}
+	if err := d.Write("users", "John", user); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "users", "John.json")); err != nil || bytes.Contains(b, []byte("322444564")) {
+		t.Fatalf("rewritten record stored %s, %v", b, err)
+	}
+}
+
+func TestEncryptFieldsBoundToRecord(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir, WithEncryptionKey([]byte("0123456789abcdef")), WithEncryptFields("users", "Contact"))
+	writeDemoUsers(t, d)
+
+	b, err := os.ReadFile(filepath.Join(dir, "users", "Arnab.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A sealed value keeps the record's key order around it.
+	if name, contact := bytes.Index(b, []byte(`"Name"`)), bytes.Index(b, []byte(`"Contact"`)); name < 0 || contact < name || bytes.Index(b, []byte(`"Address"`)) < contact {
+		t.Fatalf("sealed record reordered its keys: %s", b)
+	}
+
+	// The value sealed for Arnab does not open as John's.
+	if err := os.WriteFile(filepath.Join(dir, "users", "John.json"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var user User
+	if err := d.Read("users", "John", &user); err == nil {
+		t.Fatalf("Read of a value sealed for another record returned %+v", user)
+	}
+}
+
+func TestEncryptFieldsKeepBytes(t *testing.T) {
+	dir := t.TempDir()
+	record := "{\n  \"Zed\": 1,\n  \"Contact\": \"322444566\",\n  \"Age\": 29\n}\n"
+	if err := os.MkdirAll(filepath.Join(dir, "users"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "users", "Arnab.json"), []byte(record), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A field written before it was listed reads back byte for byte.
+	d := openTestDriver(t, dir, WithEncryptionKey([]byte("0123456789abcdef")), WithEncryptFields("users", "Contact"))
+	b, err := d.readRaw("users", "Arnab")
+	if err != nil || string(b) != record {
+		t.Fatalf("readRaw returned %q, %v; want %q", b, err, record)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import "encoding/json"
+
+// Error is the type of every condition this package reports with a sentinel
+// below. Code identifies the condition in a stable, machine-readable form,
+// e.g. to map it to an HTTP status; use errors.As to get at it through the
+// context the sentinels are usually wrapped in.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// MarshalJSON encodes e as {"code": ..., "message": ...}.
+func (e *Error) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}{e.Code, e.Message})
+}
+
+// ErrUnchanged is returned by a Migrate transform to leave a record as it is.
+var ErrUnchanged = &Error{Code: "unchanged", Message: "record unchanged"}
+
+// ErrNotObject is returned by object-only operations when the stored record
+// is not a JSON object at its top level.
+var ErrNotObject = &Error{Code: "not_object", Message: "record is not a JSON object"}
+
+// ErrReadOnly is returned by operations that would modify a database opened
+// with Options.ReadOnly.
+var ErrReadOnly = &Error{Code: "read_only", Message: "database is read-only"}
+
+// ErrNotFound is returned when the requested record does not exist.
+var ErrNotFound = &Error{Code: "not_found", Message: "record not found"}
+
+// ErrDiskFull is returned when a write fails because the disk is out of
+// space. The underlying *os.PathError is still reachable with errors.As.
+var ErrDiskFull = &Error{Code: "disk_full", Message: "disk full"}
+
+// ErrTimeout is returned when an operation exceeds Options.OperationTimeout.
+var ErrTimeout = &Error{Code: "timeout", Message: "operation timed out"}
+
+// ErrInvalidName is returned when a collection or resource name cannot be
+// used to store a record.
+var ErrInvalidName = &Error{Code: "invalid_name", Message: "invalid name"}
+
+// ErrAmbiguousName is returned when a name given for a record refers to a
+// collection directory instead.
+var ErrAmbiguousName = &Error{Code: "ambiguous_name", Message: "ambiguous name"}
+
+// ErrNotPointer is returned by Read when v is not a non-nil pointer.
+var ErrNotPointer = &Error{Code: "not_pointer", Message: "read target must be a non-nil pointer"}
+
+// ErrUnsupportedValue is returned by Write when the value holds something
+// JSON cannot represent, such as NaN or an infinite float.
+var ErrUnsupportedValue = &Error{Code: "unsupported_value", Message: "unsupported value"}
+
+// ErrExists is returned by CreateCollection when the collection is already
+// there.
+var ErrExists = &Error{Code: "already_exists", Message: "already exists"}
+
+// ErrCollectionNotFound is returned when an operation on a whole collection
+// names one that does not exist.
+var ErrCollectionNotFound = &Error{Code: "collection_not_found", Message: "collection not found"}
+
+// ErrEmptyRecord is returned by Write under Options.RejectEmpty when the
+// value encodes to null, {} or [].
+var ErrEmptyRecord = &Error{Code: "empty_record", Message: "empty record"}
+
+// ErrInvalidEncoding is returned under Options.ValidateUTF8 when a record's
+// bytes are not valid UTF-8.
+var ErrInvalidEncoding = &Error{Code: "invalid_encoding", Message: "invalid encoding"}
+
+// ErrTestFailed is returned by ApplyJSONPatch when a "test" operation does
+// not match the record.
+var ErrTestFailed = &Error{Code: "test_failed", Message: "patch test failed"}
+
+// ErrNotWritable is returned by New when the database directory exists but
+// files cannot be created in it, e.g. because of its permissions.
+var ErrNotWritable = &Error{Code: "not_writable", Message: "database directory is not writable"}
+
+// ErrClosed is returned by operations on a driver after Close.
+var ErrClosed = &Error{Code: "closed", Message: "database is closed"}
+
+// ErrWriteFailed is returned when a write could not move its temp file into
+// place. The temp file is removed and the underlying error is wrapped.
+var ErrWriteFailed = &Error{Code: "write_failed", Message: "write failed"}
+
+// ErrNameTooLong is returned when a resource name would make a file name
+// longer than Options.MaxNameLength.
+var ErrNameTooLong = &Error{Code: "name_too_long", Message: "name too long"}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestErrorCodes(t *testing.T) {
+	d := newTestDriver(t)
+
+	var user User
+	err := d.Read("users", "Nobody", &user)
+
+	var dbErr *Error
+	if !errors.As(err, &dbErr) || dbErr.Code != "not_found" {
+		t.Fatalf("Read of a missing record returned %#v, want code not_found", err)
+	}
+
+	err = d.Write("users", "Arnab.meta", user)
+	if !errors.As(err, &dbErr) || dbErr.Code != "invalid_name" {
+		t.Fatalf("Write of a bad name returned %#v, want code invalid_name", err)
+	}
+}
+
+func TestErrorMarshalJSON(t *testing.T) {
+	b, err := json.Marshal(ErrNotFound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"code":"not_found","message":"`+ErrNotFound.Message+`"}` {
+		t.Fatalf("ErrNotFound encodes as %s", b)
+	}
+}
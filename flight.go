@@ -0,0 +1,76 @@
+package main
+
+import "sync"
+
+// readFlights lets concurrent reads of the same record share one read, for
+// Options.CoalesceReads. Invalidating a record stops later reads from
+// joining a flight that may have read it before the change.
+type readFlights struct {
+	mutex sync.Mutex
+	calls map[cacheKey]*readFlight
+}
+
+type readFlight struct {
+	done chan struct{}
+	b    []byte
+	err  error
+}
+
+func newReadFlights() *readFlights {
+	return &readFlights{calls: make(map[cacheKey]*readFlight)}
+}
+
+// do returns what read returns, calling it only if no read of the record is
+// already in flight and otherwise waiting for that one. Every caller but the
+// one that read gets its own copy of the bytes. It is safe to call on nil
+// flights, which never share.
+func (f *readFlights) do(collection, resource string, read func() ([]byte, error)) ([]byte, error) {
+	if f == nil {
+		return read()
+	}
+
+	key := cacheKey{collection, resource}
+
+	f.mutex.Lock()
+	if call, ok := f.calls[key]; ok {
+		f.mutex.Unlock()
+		<-call.done
+		return append([]byte(nil), call.b...), call.err
+	}
+	call := &readFlight{done: make(chan struct{})}
+	f.calls[key] = call
+	f.mutex.Unlock()
+
+	call.b, call.err = read()
+
+	f.mutex.Lock()
+	if f.calls[key] == call {
+		delete(f.calls, key)
+	}
+	f.mutex.Unlock()
+	close(call.done)
+
+	return call.b, call.err
+}
+
+// forget detaches the flight of a record, or of every record of collection
+// if resource is empty, so reads starting afterwards read again. It is safe
+// to call on nil flights.
+func (f *readFlights) forget(collection, resource string) {
+	if f == nil {
+		return
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if resource != "" {
+		delete(f.calls, cacheKey{collection, resource})
+		return
+	}
+	for key := range f.calls {
+		if key.collection == collection {
+			delete(f.calls, key)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedCompressor is a countingCompressor whose reads wait until release is
+// closed, so concurrent reads pile up behind the first.
+type gatedCompressor struct {
+	countingCompressor
+	release chan struct{}
+}
+
+func (gatedCompressor) Ext() string { return ".gate" }
+
+func (c gatedCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	r, err := c.countingCompressor.Decompress(r)
+	<-c.release
+	return r, err
+}
+
+func readConcurrently(t *testing.T, d *Driver, n int, release chan struct{}) []User {
+	t.Helper()
+
+	users := make([]User, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = d.Read("users", "Arnab", &users[i])
+		}(i)
+	}
+
+	// Give every reader time to start before the first read finishes.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return users
+}
+
+func TestCoalescedReads(t *testing.T) {
+	codec := gatedCompressor{newCountingCompressor(), make(chan struct{})}
+	d := newTestDriver(t, WithCoalesceReads(true), WithCompressor(codec))
+	writeDemoUsers(t, d)
+
+	for _, user := range readConcurrently(t, d, 16, codec.release) {
+		if user.Name != "Arnab" || user.Company != "DAPL" {
+			t.Fatalf("coalesced Read returned %+v", user)
+		}
+	}
+	if reads := codec.Reads(); reads != 1 {
+		t.Fatalf("16 concurrent reads hit disk %d times, want once", reads)
+	}
+
+	// A write in between makes the next read go to disk again.
+	if err := d.SetField("users", "Arnab", "Company", "Acme"); err != nil {
+		t.Fatal(err)
+	}
+	var user User
+	if err := d.Read("users", "Arnab", &user); err != nil || user.Company != "Acme" {
+		t.Fatalf("Read after a write returned %+v, %v", user, err)
+	}
+}
+
+func TestUncoalescedReads(t *testing.T) {
+	codec := gatedCompressor{newCountingCompressor(), make(chan struct{})}
+	d := newTestDriver(t, WithCompressor(codec))
+	writeDemoUsers(t, d)
+
+	readConcurrently(t, d, 4, codec.release)
+	if reads := codec.Reads(); reads != 4 {
+		t.Fatalf("4 concurrent reads hit disk %d times without coalescing", reads)
+	}
+}
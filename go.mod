@@ -1,5 +1,5 @@
 module github.com/arnab333/golang-json-database
 
-go 1.17
+go 1.20
 
-require github.com/jcelliott/lumber v0.0.0-20160324203708-dd349441af25 // indirect
+require github.com/jcelliott/lumber v0.0.0-20160324203708-dd349441af25
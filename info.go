@@ -0,0 +1,445 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+// ModTime returns when the record was last written.
+func (d *Driver) ModTime(collection, resource string) (time.Time, error) {
+	leave, err := d.enter()
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer leave()
+
+	if collection == "" {
+		return time.Time{}, fmt.Errorf("missing collection - unable to read")
+	}
+	if resource == "" {
+		return time.Time{}, fmt.Errorf("missing resource - unable to read record (no name)")
+	}
+
+	var path string
+	if d.opts.Layout == SingleFile {
+		if _, err := d.readRecord(collection, resource); err != nil {
+			return time.Time{}, err
+		}
+		path = d.collectionFile(collection)
+	} else {
+		var err error
+		if path, _, err = d.stat(collection, resource); os.IsNotExist(err) {
+			return time.Time{}, ErrNotFound
+		} else if err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return time.Time{}, ErrNotFound
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+// Newest decodes the most recently written record of collection into v and
+// returns its resource, or ErrNotFound if the collection is empty. Records
+// still held by Options.BufferWrites count as written now. Ties, including
+// every record under the SingleFile layout, go to the first resource name.
+func (d *Driver) Newest(collection string, v interface{}) (string, error) {
+	return d.readByModTime(collection, v, time.Time.After)
+}
+
+// Oldest is Newest for the least recently written record.
+func (d *Driver) Oldest(collection string, v interface{}) (string, error) {
+	return d.readByModTime(collection, v, time.Time.Before)
+}
+
+// readByModTime decodes into v the record of collection whose modification
+// time wins against every other by better.
+func (d *Driver) readByModTime(collection string, v interface{}, better func(t, than time.Time) bool) (string, error) {
+	if collection == "" {
+		return "", fmt.Errorf("missing collection - unable to read")
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return "", fmt.Errorf("%w: got %T", ErrNotPointer, v)
+	}
+
+	var picked string
+
+	err := d.withTimeout(func() error {
+		mutex := d.getOrCreateMutex(collection)
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		resources, err := d.resources(collection)
+		if err == nil && len(resources) == 0 {
+			err = fmt.Errorf("%w: %v is empty", ErrNotFound, collection)
+		}
+		if os.IsNotExist(err) {
+			err = fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
+		if err != nil {
+			return err
+		}
+
+		var pickedTime time.Time
+		for i, resource := range resources {
+			t, err := d.writtenAt(collection, resource)
+			if err != nil {
+				return err
+			}
+			if i == 0 || better(t, pickedTime) {
+				picked, pickedTime = resource, t
+			}
+		}
+
+		b, err := d.readRecord(collection, picked)
+		if err != nil {
+			return err
+		}
+		if err := d.decode(b, v); err != nil {
+			return decodeError(collection, picked, b, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return picked, nil
+}
+
+// writtenAt is ModTime for a caller that holds the collection lock and knows
+// the record exists.
+func (d *Driver) writtenAt(collection, resource string) (time.Time, error) {
+	if _, ok := d.buffer.get(collection, resource); ok {
+		return time.Now(), nil
+	}
+
+	path := d.collectionFile(collection)
+	if d.opts.Layout != SingleFile {
+		var err error
+		if path, _, err = d.stat(collection, resource); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+// ExistsMany reports, for each of resources, whether it is stored in
+// collection or staged by Options.BufferWrites. The collection lock is held
+// for the whole check.
+func (d *Driver) ExistsMany(collection string, resources []string) (map[string]bool, error) {
+	leave, err := d.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	if collection == "" {
+		return nil, fmt.Errorf("missing collection - unable to read")
+	}
+
+	mutex := d.getOrCreateMutex(collection)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	exists := make(map[string]bool, len(resources))
+
+	if d.opts.Layout == SingleFile {
+		records, err := d.readCollectionFile(collection)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, resource := range resources {
+			_, staged := d.buffer.get(collection, resource)
+			_, stored := records[resource]
+			exists[resource] = staged || stored
+		}
+		return exists, nil
+	}
+
+	for _, resource := range resources {
+		if _, staged := d.buffer.get(collection, resource); staged {
+			exists[resource] = true
+			continue
+		}
+		_, _, err := d.stat(collection, resource)
+		switch {
+		case err == nil:
+			exists[resource] = true
+		case os.IsNotExist(err):
+			exists[resource] = false
+		default:
+			return nil, err
+		}
+	}
+	return exists, nil
+}
+
+// Keys returns the names of the records in collection without reading them.
+// A collection that does not exist has no keys.
+func (d *Driver) Keys(collection string) ([]string, error) {
+	leave, err := d.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	if collection == "" {
+		return nil, fmt.Errorf("missing collection - unable to read")
+	}
+
+	resources, _, err := d.tieredRecords(collection)
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resources == nil {
+		resources = []string{}
+	}
+	return resources, nil
+}
+
+// TotalRecords counts the records stored across every collection.
+func (d *Driver) TotalRecords() (int, error) {
+	leave, err := d.enter()
+	if err != nil {
+		return 0, err
+	}
+	defer leave()
+
+	collections, err := d.collections()
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, collection := range collections {
+		resources, err := d.resources(collection)
+		if err != nil {
+			return 0, err
+		}
+		total += len(resources)
+	}
+	return total, nil
+}
+
+// Overview returns the number of records in every collection, keyed by
+// collection name. Temp files and sidecars are not counted.
+func (d *Driver) Overview() (map[string]int, error) {
+	leave, err := d.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	collections, err := d.collections()
+	if err != nil {
+		return nil, err
+	}
+
+	overview := make(map[string]int, len(collections))
+	for _, collection := range collections {
+		resources, err := d.resources(collection)
+		if err != nil {
+			return nil, err
+		}
+		overview[collection] = len(resources)
+	}
+	return overview, nil
+}
+
+// AllKeys returns the names of the records in every collection, keyed by
+// collection name, e.g. to build an index across the whole database. Temp
+// files and sidecars are left out.
+func (d *Driver) AllKeys() (map[string][]string, error) {
+	leave, err := d.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	collections, err := d.collections()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string][]string, len(collections))
+	for _, collection := range collections {
+		resources, err := d.resources(collection)
+		if err != nil {
+			return nil, err
+		}
+		if resources == nil {
+			resources = []string{}
+		}
+		keys[collection] = resources
+	}
+	return keys, nil
+}
+
+// ReadAllSince returns the records in collection modified after since, keyed
+// by resource, including those found under Options.ReadDirs. Records still
+// held by Options.BufferWrites count as modified now. Under the SingleFile
+// layout the collection changes as a whole, so either every record or none
+// is returned.
+func (d *Driver) ReadAllSince(collection string, since time.Time) (map[string]string, error) {
+	leave, err := d.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	if collection == "" {
+		return nil, fmt.Errorf("missing collection - unable to read")
+	}
+
+	resources, owners, err := d.tieredOwners(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make(map[string]string)
+
+	for _, resource := range resources {
+		owner := owners[resource]
+
+		t, err := owner.view.writtenAt(collection, resource)
+		if err != nil {
+			return nil, err
+		}
+		if !t.After(since) {
+			continue
+		}
+
+		b, err := owner.read(resource)
+		if err != nil {
+			return nil, err
+		}
+		records[resource] = string(b)
+	}
+	return records, nil
+}
+
+// Hash returns the hex SHA-256 of a record's bytes, usable as a strong ETag.
+// It is taken over the JSON as Read sees it, after any decompression, so it
+// does not change when only Options.Compress does.
+func (d *Driver) Hash(collection, resource string) (string, error) {
+	if collection == "" {
+		return "", fmt.Errorf("missing collection - unable to read")
+	}
+	if resource == "" {
+		return "", fmt.Errorf("missing resource - unable to read record (no name)")
+	}
+
+	b, err := d.readRaw(collection, resource)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Bucket counts the records whose size falls in [Min, Max). A Max of 0 means
+// the bucket has no upper bound.
+type Bucket struct {
+	Min   int64
+	Max   int64
+	Count int
+}
+
+// sizeBuckets are the upper bounds SizeHistogram sorts records into.
+var sizeBuckets = []int64{1 << 10, 10 << 10, 100 << 10, 1 << 20, 10 << 20}
+
+// SizeHistogram counts the records in collection by size: under 1KB,
+// 1-10KB, 10-100KB, 100KB-1MB, 1-10MB and over 10MB. Sizes are as stored,
+// so compressed records count at their compressed size, and under the
+// SingleFile layout each record counts at the size of its entry in the
+// collection file.
+func (d *Driver) SizeHistogram(collection string) ([]Bucket, error) {
+	leave, err := d.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	if collection == "" {
+		return nil, fmt.Errorf("missing collection - unable to read")
+	}
+
+	buckets := make([]Bucket, len(sizeBuckets)+1)
+	var min int64
+	for i, max := range sizeBuckets {
+		buckets[i] = Bucket{Min: min, Max: max}
+		min = max
+	}
+	buckets[len(sizeBuckets)] = Bucket{Min: min}
+
+	add := func(size int64) {
+		for i := range buckets {
+			if buckets[i].Max == 0 || size < buckets[i].Max {
+				buckets[i].Count++
+				return
+			}
+		}
+	}
+
+	if d.opts.Layout == SingleFile {
+		records, err := d.readCollectionFile(collection)
+		if err != nil {
+			return nil, err
+		}
+		for _, raw := range records {
+			add(int64(len(raw)))
+		}
+		return buckets, nil
+	}
+
+	dir := filepath.Join(*d.dir, collection)
+
+	if d.opts.PathFor != nil {
+		err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if _, ok := d.recordName(filepath.Dir(path), fi); ok {
+				add(fi.Size())
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return buckets, nil
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if _, ok := d.recordName(dir, file); ok {
+			add(file.Size())
+		}
+	}
+	return buckets, nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestModTime(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+	writeDemoUsers(t, d)
+
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(filepath.Join(dir, "users", "Arnab.json"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.ModTime("users", "Arnab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(old) {
+		t.Fatalf("ModTime = %v, want %v", got, old)
+	}
+
+	start := time.Now().Add(-time.Second)
+	if err := d.Write("users", "Arnab", demoUsers()[0]); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = d.ModTime("users", "Arnab"); err != nil {
+		t.Fatal(err)
+	}
+	if got.Before(start) {
+		t.Fatalf("ModTime = %v after a write at %v", got, start)
+	}
+
+	if _, err := d.ModTime("users", "Nobody"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("ModTime of a missing record returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestExistsMany(t *testing.T) {
+	for name, options := range map[string][]Option{
+		"PerFile":    nil,
+		"SingleFile": {WithLayout(SingleFile)},
+		"Buffered":   {WithBufferWrites(0, 0)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			d := newTestDriver(t, options...)
+			writeDemoUsers(t, d)
+
+			exists, err := d.ExistsMany("users", []string{"Arnab", "Nobody", "Jane", "Zed"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]bool{"Arnab": true, "Nobody": false, "Jane": true, "Zed": false}
+			if len(exists) != len(want) {
+				t.Fatalf("ExistsMany returned %v, want %v", exists, want)
+			}
+			for resource, present := range want {
+				if exists[resource] != present {
+					t.Errorf("ExistsMany[%v] = %v, want %v", resource, exists[resource], present)
+				}
+			}
+		})
+	}
+}
+
+func TestKeys(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+	writeDemoUsers(t, d)
+	if err := os.WriteFile(filepath.Join(dir, "users", "Zed.json.tmp"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := d.Keys("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(keys) != "[Arnab Harry Jane John Paul Rahul]" {
+		t.Fatalf("Keys = %v", keys)
+	}
+
+	keys, err = d.Keys("missing")
+	if err != nil || len(keys) != 0 {
+		t.Fatalf("Keys of a missing collection = %v, %v", keys, err)
+	}
+}
+
+func TestTotalRecords(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+
+	if total, err := d.TotalRecords(); err != nil || total != 0 {
+		t.Fatalf("TotalRecords of an empty database = %d, %v", total, err)
+	}
+
+	writeDemoUsers(t, d)
+	writeEvents(t, d, 4)
+	if err := d.SetMeta("users", "Arnab", map[string]string{"owner": "ops"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.WriteBlob("users", "Arnab", strings.NewReader("avatar")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "events", "e0009.json.tmp"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	total, err := d.TotalRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := len(demoUsers()) + 4; total != want {
+		t.Fatalf("TotalRecords = %d, want %d", total, want)
+	}
+}
+
+func TestReadAllSince(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+	writeDemoUsers(t, d)
+
+	// Backdate the first batch rather than sleeping past the file system's
+	// timestamp resolution.
+	old := time.Now().Add(-time.Hour)
+	for _, user := range demoUsers() {
+		if err := os.Chtimes(filepath.Join(dir, "users", user.Name+".json"), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	since := time.Now().Add(-time.Minute)
+
+	mustWrite(t, d, "users", map[string]interface{}{
+		"Zed":   User{Name: "Zed"},
+		"Arnab": demoUsers()[0],
+	})
+
+	records, err := d.ReadAllSince("users", since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records["Zed"] == "" || records["Arnab"] == "" {
+		t.Fatalf("ReadAllSince returned %v, want Zed and Arnab", records)
+	}
+	var zed User
+	if err := json.Unmarshal([]byte(records["Zed"]), &zed); err != nil || zed.Name != "Zed" {
+		t.Fatalf("Zed came back as %q", records["Zed"])
+	}
+}
+
+func TestHash(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+	mustWrite(t, d, "users", map[string]interface{}{"a": demoUsers()[0], "b": demoUsers()[0]})
+
+	a, err := d.Hash("users", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := d.Hash("users", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b || len(a) != 64 {
+		t.Fatalf("identical records hash to %v and %v", a, b)
+	}
+
+	if err := d.SetField("users", "b", "Company", "Acme"); err != nil {
+		t.Fatal(err)
+	}
+	if changed, _ := d.Hash("users", "b"); changed == a {
+		t.Fatal("changing a record kept its hash")
+	}
+
+	// Compression does not change what is hashed.
+	if err := d.With(WithCompress(true)).Write("users", "c", demoUsers()[0]); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := d.Hash("users", "c"); c != a {
+		t.Fatalf("compressed copy hashes to %v, want %v", c, a)
+	}
+
+	if _, err := d.Hash("users", "Nobody"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Hash of a missing record returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestOverview(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+
+	if overview, err := d.Overview(); err != nil || len(overview) != 0 {
+		t.Fatalf("Overview of an empty database = %v, %v", overview, err)
+	}
+
+	writeDemoUsers(t, d)
+	writeEvents(t, d, 3)
+	if err := d.EnsureCollection("empty"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetMeta("users", "Arnab", map[string]string{"owner": "ops"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "events", "e0009.json.tmp"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	overview, err := d.Overview()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(overview) != fmt.Sprintf("map[empty:0 events:3 users:%d]", len(demoUsers())) {
+		t.Fatalf("Overview = %v", overview)
+	}
+}
+
+func TestSizeHistogram(t *testing.T) {
+	d := newTestDriver(t)
+	for resource, size := range map[string]int{
+		"tiny1": 10, "tiny2": 500, "small": 5 << 10, "medium": 50 << 10, "large": 2 << 20,
+	} {
+		if err := d.Write("blobs", resource, strings.Repeat("x", size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buckets, err := d.SizeHistogram("blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var counts []int
+	for _, bucket := range buckets {
+		counts = append(counts, bucket.Count)
+	}
+	if fmt.Sprint(counts) != "[2 1 1 0 1 0]" {
+		t.Fatalf("SizeHistogram counts = %v, want [2 1 1 0 1 0]", counts)
+	}
+	if buckets[0].Min != 0 || buckets[0].Max != 1<<10 || buckets[5].Max != 0 {
+		t.Fatalf("bucket bounds = %+v", buckets)
+	}
+}
+
+func TestAllKeys(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+	writeDemoUsers(t, d)
+	writeEvents(t, d, 2)
+	if err := d.EnsureCollection("empty"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetMeta("users", "Arnab", map[string]string{"owner": "ops"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "users", "Paul.json.tmp"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := d.AllKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"users":  {"Arnab", "Harry", "Jane", "John", "Paul", "Rahul"},
+		"events": {"e0000", "e0001"},
+		"empty":  {},
+	}
+	if len(keys) != len(want) {
+		t.Fatalf("AllKeys returned collections %v, want %v", keys, want)
+	}
+	for collection, resources := range want {
+		got := append([]string(nil), keys[collection]...)
+		sort.Strings(got)
+		if keys[collection] == nil || strings.Join(got, ",") != strings.Join(resources, ",") {
+			t.Errorf("AllKeys()[%v] = %q, want %q", collection, keys[collection], resources)
+		}
+	}
+}
+
+func TestNewestOldest(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+
+	var user User
+	if _, err := d.Newest("users", &user); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Newest of a missing collection returned %v, want ErrNotFound", err)
+	}
+	if err := d.EnsureCollection("users"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Oldest("users", &user); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Oldest of an empty collection returned %v, want ErrNotFound", err)
+	}
+
+	// Written in name order an hour apart, except that Paul is the oldest.
+	writeDemoUsers(t, d)
+	base := time.Now().Add(-24 * time.Hour)
+	for i, name := range []string{"Paul", "Arnab", "Harry", "Jane", "Rahul", "John"} {
+		at := base.Add(time.Duration(i) * time.Hour)
+		if err := os.Chtimes(filepath.Join(dir, "users", name+".json"), at, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if resource, err := d.Newest("users", &user); err != nil || resource != "John" || user.Name != "John" {
+		t.Fatalf("Newest returned %v, %+v, %v", resource, user, err)
+	}
+	if resource, err := d.Oldest("users", &user); err != nil || resource != "Paul" || user.Company != "Adobe" {
+		t.Fatalf("Oldest returned %v, %+v, %v", resource, user, err)
+	}
+
+	// A fresh write is the newest.
+	if err := d.SetField("users", "Harry", "Company", "Acme"); err != nil {
+		t.Fatal(err)
+	}
+	if resource, err := d.Newest("users", &user); err != nil || resource != "Harry" || user.Company != "Acme" {
+		t.Fatalf("Newest after a write returned %v, %+v, %v", resource, user, err)
+	}
+}
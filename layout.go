@@ -0,0 +1,574 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Layout selects how collections are arranged on disk.
+type Layout int
+
+const (
+	// PerFile stores every record in its own <collection>/<resource>.json
+	// file. It is the default.
+	PerFile Layout = iota
+
+	// SingleFile stores a whole collection as one <collection>.json object
+	// mapping resource names to records. It avoids an inode per record, but
+	// every change rewrites the whole file, so it suits collections of many
+	// small records that change rarely.
+	SingleFile
+)
+
+const (
+	jsonExt = ".json"
+)
+
+// extensions lists the extensions a PerFile record may be stored under, the
+// one new writes use first.
+func (d *Driver) extensions() []string {
+	exts := []string{jsonExt}
+	for _, c := range d.compressors() {
+		exts = append(exts, jsonExt+c.Ext())
+	}
+
+	if d.opts.Compress {
+		exts[0], exts[1] = exts[1], exts[0]
+	}
+	return exts
+}
+
+// recordExt returns the record extension name ends in, if any.
+func (d *Driver) recordExt(name string) (string, bool) {
+	for _, ext := range d.extensions() {
+		if strings.HasSuffix(name, ext) && len(name) > len(ext) {
+			return ext, true
+		}
+	}
+	return "", false
+}
+
+// keyEncoding turns resource names into file names under Options.EncodeKeys.
+// Lowercase base32 uses only characters every filesystem accepts and stays
+// unambiguous on case-insensitive ones.
+var keyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// fileName returns the name resource is stored under on disk.
+func (d *Driver) fileName(resource string) string {
+	if !d.opts.EncodeKeys {
+		return resource
+	}
+	return strings.ToLower(keyEncoding.EncodeToString([]byte(resource)))
+}
+
+// resourceName is the inverse of fileName. It reports false for a file name
+// that fileName could not have produced.
+func (d *Driver) resourceName(name string) (string, bool) {
+	if !d.opts.EncodeKeys {
+		return name, true
+	}
+	if name != strings.ToLower(name) {
+		return "", false
+	}
+	b, err := keyEncoding.DecodeString(strings.ToUpper(name))
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
+
+// checkPath enforces what Options.PathFor must return for a record: a path
+// inside the collection's directory that ends in the resource name. Records
+// it places anywhere else are refused with ErrInvalidName.
+func (d *Driver) checkPath(collection, resource string) error {
+	if d.opts.PathFor == nil {
+		return nil
+	}
+
+	name := filepath.Clean(filepath.FromSlash(d.fileName(resource)))
+	path := d.opts.PathFor(collection, d.fileName(resource))
+	clean := filepath.Clean(filepath.FromSlash(path))
+	sep := string(filepath.Separator)
+
+	if !filepath.IsLocal(clean) || !strings.HasPrefix(clean, collection+sep) || !strings.HasSuffix(sep+clean, sep+name) {
+		return fmt.Errorf("%w: PathFor put %v/%v at %q, which is not inside its collection and ending in its name", ErrInvalidName, collection, resource, path)
+	}
+	return nil
+}
+
+// recordBase returns where a PerFile record is stored, minus the extension.
+// Under Options.PathFor, callers must have checked the path with checkPath.
+func (d *Driver) recordBase(collection, resource string) string {
+	resource = d.fileName(resource)
+	if d.opts.PathFor != nil {
+		return filepath.Join(*d.dir, filepath.FromSlash(d.opts.PathFor(collection, resource)))
+	}
+	return filepath.Join(*d.dir, collection, resource)
+}
+
+// recordFile returns the file a PerFile record is written to.
+func (d *Driver) recordFile(collection, resource string) string {
+	return d.recordBase(collection, resource) + d.extensions()[0]
+}
+
+// stat finds the file a PerFile record is stored in, trying each extension
+// it may have been written with, and returns the path that matched. Like
+// os.Lstat it does not follow symlinks. A record that is not stored under
+// any extension yields the not-exist error for recordFile.
+func (d *Driver) stat(collection, resource string) (string, os.FileInfo, error) {
+	if err := d.checkPath(collection, resource); err != nil {
+		return "", nil, err
+	}
+	base := d.recordBase(collection, resource)
+
+	var notExist error
+	for _, ext := range d.extensions() {
+		fi, err := os.Lstat(base + ext)
+		if err == nil {
+			return base + ext, fi, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", nil, err
+		}
+		if notExist == nil {
+			notExist = err
+		}
+	}
+	return "", nil, notExist
+}
+
+// collectionFile returns the file a SingleFile collection is stored in.
+func (d *Driver) collectionFile(collection string) string {
+	return filepath.Join(*d.dir, collection+".json")
+}
+
+// readRecord returns the stored bytes of a record, with any fields listed in
+// Options.EncryptFields decrypted, or ErrNotFound.
+func (d *Driver) readRecord(collection, resource string) ([]byte, error) {
+	if b, ok := d.buffer.get(collection, resource); ok {
+		return d.openFields(collection, resource, b)
+	}
+
+	b, err := d.fetchRecord(collection, resource)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkEncoding(collection, resource, b); err != nil {
+		return nil, err
+	}
+	return d.openFields(collection, resource, b)
+}
+
+func (d *Driver) fetchRecord(collection, resource string) ([]byte, error) {
+	if d.opts.Layout == SingleFile {
+		records, err := d.readCollectionFile(collection)
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		raw, ok := records[resource]
+		if !ok {
+			return nil, fmt.Errorf("%w: %v in %v", ErrNotFound, resource, d.collectionFile(collection))
+		}
+		return indentRecord(raw), nil
+	}
+
+	path, _, err := d.stat(collection, resource)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := d.readFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	return b, err
+}
+
+// records lists the resources in collection along with a function reading
+// any one of them, like readRecord. A SingleFile collection is only loaded
+// once.
+func (d *Driver) records(collection string) ([]string, func(resource string) ([]byte, error), error) {
+	leave, err := d.enter()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer leave()
+
+	resources, read, err := d.storedRecords(collection)
+	if d.buffer != nil {
+		resources, err = d.buffer.merge(collection, resources, err)
+		stored := read
+		read = func(resource string) ([]byte, error) {
+			if b, ok := d.buffer.get(collection, resource); ok {
+				return b, nil
+			}
+			return stored(resource)
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !d.encrypts(collection) {
+		return resources, read, nil
+	}
+	return resources, func(resource string) ([]byte, error) {
+		b, err := read(resource)
+		if err != nil {
+			return nil, err
+		}
+		return d.openFields(collection, resource, b)
+	}, nil
+}
+
+// storedRecords is records without writes still held by Options.BufferWrites.
+func (d *Driver) storedRecords(collection string) ([]string, func(resource string) ([]byte, error), error) {
+	if d.opts.Layout == SingleFile {
+		records, err := d.readCollectionFile(collection)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		read := func(resource string) ([]byte, error) {
+			raw, ok := records[resource]
+			if !ok {
+				return nil, fmt.Errorf("%w: %v in %v", ErrNotFound, resource, d.collectionFile(collection))
+			}
+			return indentRecord(raw), nil
+		}
+		return sortedKeys(records), d.checkedRead(collection, read), nil
+	}
+
+	resources, err := d.storedResources(collection)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	read := func(resource string) ([]byte, error) {
+		path, _, err := d.stat(collection, resource)
+		if err != nil {
+			return nil, err
+		}
+		return d.readFile(path)
+	}
+	return resources, d.checkedRead(collection, read), nil
+}
+
+// tieredRecords is records over the primary directory and Options.ReadDirs
+// together. Every record is read from the first directory holding it, and
+// the collection only has to exist in one of them.
+func (d *Driver) tieredRecords(collection string) ([]string, func(resource string) ([]byte, error), error) {
+	names, owners, err := d.tieredOwners(collection)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return names, func(resource string) ([]byte, error) {
+		owner, ok := owners[resource]
+		if !ok {
+			return nil, fmt.Errorf("%w: %v in %v", ErrNotFound, resource, collection)
+		}
+		return owner.read(resource)
+	}, nil
+}
+
+// tieredOwner is where tieredOwners found a record: the driver or view of a
+// read dir it is stored under, and its records reading function.
+type tieredOwner struct {
+	view *Driver
+	read func(resource string) ([]byte, error)
+}
+
+// tieredOwners lists the resources of tieredRecords, sorted, along with the
+// owner of each.
+func (d *Driver) tieredOwners(collection string) ([]string, map[string]tieredOwner, error) {
+	resources, read, err := d.records(collection)
+	if err != nil && (len(d.opts.ReadDirs) == 0 || !os.IsNotExist(err)) {
+		return nil, nil, err
+	}
+
+	primaryErr, found := err, err == nil
+	owners := make(map[string]tieredOwner, len(resources))
+	for _, resource := range resources {
+		owners[resource] = tieredOwner{d, read}
+	}
+	if len(d.opts.ReadDirs) == 0 {
+		return resources, owners, nil
+	}
+
+	for _, dir := range d.opts.ReadDirs {
+		view := d.at(dir)
+		resources, read, err := view.records(collection)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		found = true
+		for _, resource := range resources {
+			if _, ok := owners[resource]; !ok {
+				owners[resource] = tieredOwner{view, read}
+			}
+		}
+	}
+	if !found {
+		return nil, nil, primaryErr
+	}
+
+	names := make([]string, 0, len(owners))
+	for resource := range owners {
+		names = append(names, resource)
+	}
+	sort.Strings(names)
+	return names, owners, nil
+}
+
+// checkedRead wraps a record reading function of records with
+// checkEncoding.
+func (d *Driver) checkedRead(collection string, read func(resource string) ([]byte, error)) func(resource string) ([]byte, error) {
+	if !d.opts.ValidateUTF8 {
+		return read
+	}
+	return func(resource string) ([]byte, error) {
+		b, err := read(resource)
+		if err != nil {
+			return nil, err
+		}
+		if err := d.checkEncoding(collection, resource, b); err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+}
+
+// checkEncoding enforces Options.ValidateUTF8 on the bytes of a record.
+func (d *Driver) checkEncoding(collection, resource string, b []byte) error {
+	if d.opts.ValidateUTF8 && !utf8.Valid(b) {
+		return fmt.Errorf("%w: %v/%v is not valid UTF-8", ErrInvalidEncoding, collection, resource)
+	}
+	return nil
+}
+
+// writeRecord stores b as resource in collection and reports whether it
+// replaced an existing record. The caller must hold the collection lock.
+func (d *Driver) writeRecord(collection, resource string, b []byte) (bool, error) {
+	d.buffer.discard(collection, resource)
+	d.forget(collection, resource)
+
+	if d.opts.Layout == SingleFile {
+		records, err := d.readCollectionFile(collection)
+		if err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+		if records == nil {
+			records = make(map[string]json.RawMessage)
+		}
+
+		_, existed := records[resource]
+		records[resource] = json.RawMessage(b)
+
+		if err := d.writeCollectionFile(collection, records); err != nil {
+			return existed, err
+		}
+		return existed, d.logChange(collection, false, resource)
+	}
+
+	path := d.recordFile(collection, resource)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, diskError(err)
+	}
+
+	var existed bool
+	if d.opts.TrackOverwrites {
+		_, _, err := d.stat(collection, resource)
+		existed = err == nil
+	}
+
+	crowding := d.opts.DirSizeWarnThreshold > 0 && d.opts.PathFor == nil
+	if crowding {
+		_, _, err := d.stat(collection, resource)
+		crowding = os.IsNotExist(err)
+	}
+
+	if err := d.writeFile(path, b); err != nil {
+		return existed, err
+	}
+	if err := d.removeStale(collection, resource); err != nil {
+		return existed, err
+	}
+	if crowding {
+		d.warnIfCrowded(collection)
+	}
+	return existed, d.logChange(collection, false, resource)
+}
+
+// checkName rejects a resource name that cannot be written to collection:
+// one reserved for sidecars, or under the PerFile layout one whose temp file
+// name would exceed Options.MaxNameLength.
+func (d *Driver) checkName(collection, resource string) error {
+	if err := checkReserved(resource); err != nil {
+		return err
+	}
+	if d.opts.Layout == SingleFile {
+		return nil
+	}
+	if err := d.checkPath(collection, resource); err != nil {
+		return err
+	}
+
+	name := filepath.Base(d.recordFile(collection, resource)) + d.opts.TempSuffix
+	if len(name) > d.opts.MaxNameLength {
+		return fmt.Errorf("%w: file name for %v would be %d bytes, over the limit of %d", ErrNameTooLong, resource, len(name), d.opts.MaxNameLength)
+	}
+	return nil
+}
+
+// removeStale drops any copy of a freshly written record left under another
+// extension, e.g. from before Options.Compress was changed, so only the new
+// one is ever found.
+func (d *Driver) removeStale(collection, resource string) error {
+	base := d.recordBase(collection, resource)
+	for _, ext := range d.extensions()[1:] {
+		if err := os.Remove(base + ext); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Driver) readCollectionFile(collection string) (map[string]json.RawMessage, error) {
+	path := d.collectionFile(collection)
+
+	b, err := d.readFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var records map[string]json.RawMessage
+	if err := json.Unmarshal(b, &records); err != nil {
+		return nil, fmt.Errorf("unable to read collection %v: %w", path, err)
+	}
+	return records, nil
+}
+
+func (d *Driver) writeCollectionFile(collection string, records map[string]json.RawMessage) error {
+	d.forget(collection, "")
+
+	path := d.collectionFile(collection)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return diskError(err)
+	}
+
+	b, err := d.encodeCollectionFile(records)
+	if err != nil {
+		return err
+	}
+	return d.writeFile(path, b)
+}
+
+// encodeCollectionFile encodes records the way a SingleFile collection file
+// stores them.
+func (d *Driver) encodeCollectionFile(records map[string]json.RawMessage) ([]byte, error) {
+	b, err := json.MarshalIndent(records, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	if !d.opts.NoTrailingNewline {
+		b = append(b, byte('\n'))
+	}
+	return b, nil
+}
+
+// deleteFromCollectionFile is delete for the SingleFile layout. An empty
+// resource drops the whole collection.
+func (d *Driver) deleteFromCollectionFile(collection, resource string) error {
+	path := d.collectionFile(collection)
+
+	records, err := d.readCollectionFile(collection)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("unable to find file or directory named %v", path)
+	}
+	if err != nil {
+		return err
+	}
+
+	if resource == "" {
+		if d.opts.DryRun {
+			for _, r := range sortedKeys(records) {
+				d.log.Info("Would delete '%s' from '%s'\n", r, path)
+			}
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(filepath.Join(*d.dir, collection)); err != nil {
+			return err
+		}
+		return d.logChange(collection, true, "")
+	}
+
+	if _, ok := records[resource]; !ok {
+		return fmt.Errorf("unable to find record %v in %v", resource, path)
+	}
+	if d.opts.DryRun {
+		d.log.Info("Would delete '%s' from '%s'\n", resource, path)
+		return nil
+	}
+
+	delete(records, resource)
+	if err := d.writeCollectionFile(collection, records); err != nil {
+		return err
+	}
+	if err := d.removeSidecars(collection, resource); err != nil {
+		return err
+	}
+	return d.logChange(collection, true, resource)
+}
+
+// sidecars lists the files kept alongside a record that go away with it.
+func (d *Driver) sidecars(collection, resource string) []string {
+	return []string{d.blobFile(collection, resource), d.metaFile(collection, resource)}
+}
+
+func (d *Driver) removeSidecars(collection, resource string) error {
+	for _, path := range d.sidecars(collection, resource) {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func indentRecord(raw []byte) []byte {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, raw, "", "\t"); err != nil {
+		return raw
+	}
+	return buf.Bytes()
+}
+
+func sortedKeys(records map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
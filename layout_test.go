@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var layouts = map[string]Layout{"PerFile": PerFile, "SingleFile": SingleFile}
+
+func TestLayoutsBehaveAlike(t *testing.T) {
+	for name, layout := range layouts {
+		t.Run(name, func(t *testing.T) {
+			d := newTestDriver(t, WithLayout(layout))
+			writeDemoUsers(t, d)
+
+			var user User
+			if err := d.Read("users", "Harry", &user); err != nil || user.Company != "Google" {
+				t.Fatalf("Read returned %+v, %v", user, err)
+			}
+			if err := d.Read("users", "Nobody", &user); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Read of a missing record returned %v, want ErrNotFound", err)
+			}
+
+			records, err := d.ReadAll("users")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, user := range decodeAll[User](t, records) {
+				names = append(names, user.Name)
+			}
+			if fmt.Sprint(names) != "[Arnab Harry Jane John Paul Rahul]" {
+				t.Fatalf("ReadAll returned %v", names)
+			}
+
+			if err := d.Delete("users", "Harry"); err != nil {
+				t.Fatal(err)
+			}
+			keys, err := d.Keys("users")
+			if err != nil || fmt.Sprint(keys) != "[Arnab Jane John Paul Rahul]" {
+				t.Fatalf("Keys after Delete = %v, %v", keys, err)
+			}
+
+			if err := d.Delete("users", ""); err != nil {
+				t.Fatal(err)
+			}
+			if keys, err = d.Keys("users"); err != nil || len(keys) != 0 {
+				t.Fatalf("Keys after dropping the collection = %v, %v", keys, err)
+			}
+		})
+	}
+}
+
+func TestSingleFileStorage(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir, WithLayout(SingleFile))
+	writeDemoUsers(t, d)
+
+	if _, err := os.Stat(filepath.Join(dir, "users.json")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "users")); !os.IsNotExist(err) {
+		t.Fatalf("SingleFile created a collection directory: %v", err)
+	}
+}
+
+// shardByPrefix stores users/arnab as users/a/ar/arnab.
+func shardByPrefix(collection, resource string) string {
+	if len(resource) < 2 {
+		return collection + "/" + resource
+	}
+	return collection + "/" + resource[:1] + "/" + resource[:2] + "/" + resource
+}
+
+func TestPathForSharding(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir, WithPathFor(shardByPrefix))
+	writeDemoUsers(t, d)
+
+	if _, err := os.Stat(filepath.Join(dir, "users", "A", "Ar", "Arnab.json")); err != nil {
+		t.Fatalf("record not sharded: %v", err)
+	}
+
+	var user User
+	if err := d.Read("users", "Arnab", &user); err != nil || user.Name != "Arnab" {
+		t.Fatalf("Read returned %+v, %v", user, err)
+	}
+	keys, err := d.Keys("users")
+	if err != nil || fmt.Sprint(keys) != "[Arnab Harry Jane John Paul Rahul]" {
+		t.Fatalf("Keys = %v, %v", keys, err)
+	}
+	records, err := d.ReadAll("users")
+	if err != nil || len(records) != len(demoUsers()) {
+		t.Fatalf("ReadAll returned %d records, %v", len(records), err)
+	}
+
+	if err := d.Delete("users", "Jane"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Read("users", "Jane", &user); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Read after Delete returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestPathForEscaping(t *testing.T) {
+	for name, pathFor := range map[string]func(collection, resource string) string{
+		"outside":  func(collection, resource string) string { return "../" + resource },
+		"other":    func(collection, resource string) string { return "other/" + resource },
+		"renamed":  func(collection, resource string) string { return collection + "/x" },
+		"absolute": func(collection, resource string) string { return "/tmp/" + collection + "/" + resource },
+	} {
+		d := newTestDriver(t, WithPathFor(pathFor))
+		if err := d.Write("users", "Arnab", demoUsers()[0]); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("%v: Write returned %v, want ErrInvalidName", name, err)
+		}
+	}
+}
+
+func TestEncodeKeys(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir, WithEncodeKeys(true))
+
+	keys := []string{"Mail:a@b.com", "path/to/Thing", "UPPER", "upper"}
+	for i, key := range keys {
+		if err := d.Write("keys", key, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, key := range keys {
+		var n int
+		if err := d.Read("keys", key, &n); err != nil || n != i {
+			t.Errorf("Read(%q) = %d, %v; want %d", key, n, err, i)
+		}
+	}
+
+	got, err := d.Keys("keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != "[Mail:a@b.com UPPER path/to/Thing upper]" {
+		t.Fatalf("Keys = %q", got)
+	}
+
+	// Only encoded, filesystem-safe names are on disk.
+	files, err := os.ReadDir(filepath.Join(dir, "keys"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != len(keys) {
+		t.Fatalf("%d files on disk, want %d", len(files), len(keys))
+	}
+	for _, file := range files {
+		if name := file.Name(); strings.ContainsAny(name, "/:@") || name != strings.ToLower(name) {
+			t.Errorf("file name %q is not encoded", name)
+		}
+	}
+}
+
+func TestReadDirs(t *testing.T) {
+	dir, replica := t.TempDir(), t.TempDir()
+	mustWrite(t, openTestDriver(t, replica), "users", map[string]interface{}{
+		"Zed":   User{Name: "Zed"},
+		"Arnab": User{Name: "Arnab", Company: "Old"},
+	})
+	mustWrite(t, openTestDriver(t, replica), "archive", map[string]interface{}{"Old": User{Name: "Old"}})
+
+	d := openTestDriver(t, dir, WithReadDirs(replica))
+	writeDemoUsers(t, d)
+
+	var user User
+	if err := d.Read("users", "Zed", &user); err != nil || user.Name != "Zed" {
+		t.Fatalf("Read of a record only in the read dir returned %+v, %v", user, err)
+	}
+	// The primary comes first.
+	if err := d.Read("users", "Arnab", &user); err != nil || user.Company != "DAPL" {
+		t.Fatalf("Read of a record in both returned %+v, %v", user, err)
+	}
+
+	wantKeys := "[Arnab Harry Jane John Paul Rahul Zed]"
+	if keys, err := d.Keys("users"); err != nil || fmt.Sprint(keys) != wantKeys {
+		t.Fatalf("Keys = %v, %v", keys, err)
+	}
+	records, err := d.ReadAll("users")
+	if err != nil || len(records) != 7 {
+		t.Fatalf("ReadAll returned %d records, %v", len(records), err)
+	}
+	parallel, err := d.ReadAllParallel("users", 4)
+	if err != nil || fmt.Sprint(parallel) != fmt.Sprint(records) {
+		t.Fatalf("ReadAllParallel differs from ReadAll: %v", err)
+	}
+	since, err := d.ReadAllSince("users", time.Time{})
+	if err != nil || len(since) != 7 {
+		t.Fatalf("ReadAllSince returned %d records, %v", len(since), err)
+	}
+
+	// A collection only the read dir has is found too.
+	if err := d.Read("archive", "Old", &user); err != nil {
+		t.Fatalf("Read from a collection only in the read dir returned %v", err)
+	}
+
+	// Writes go to the primary only.
+	if err := d.Write("users", "Zed", User{Name: "Zed", Company: "New"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := openTestDriver(t, replica).Read("users", "Zed", &user); err != nil || user.Company != "" {
+		t.Fatalf("write reached the read dir: %+v, %v", user, err)
+	}
+}
+
+func TestMaxNameLength(t *testing.T) {
+	// A record's longest file name is its temp file, <resource>.json.tmp.
+	d := newTestDriver(t)
+	if err := d.Write("keys", strings.Repeat("k", 255-len(".json.tmp")), 1); err != nil {
+		t.Fatalf("Write of a name at the default limit returned %v", err)
+	}
+	if err := d.Write("keys", strings.Repeat("k", 256-len(".json.tmp")), 1); !errors.Is(err, ErrNameTooLong) {
+		t.Fatalf("Write of a name just over the default limit returned %v, want ErrNameTooLong", err)
+	}
+
+	d = newTestDriver(t, WithMaxNameLength(32))
+	if err := d.Write("keys", strings.Repeat("k", 32-len(".json.tmp")), 1); err != nil {
+		t.Fatalf("Write of a name at the limit returned %v", err)
+	}
+	long := strings.Repeat("k", 33-len(".json.tmp"))
+	if err := d.Write("keys", long, 1); !errors.Is(err, ErrNameTooLong) || !strings.Contains(err.Error(), long) {
+		t.Fatalf("Write of a name just over the limit returned %v, want ErrNameTooLong", err)
+	}
+
+	// Compression makes the file name longer.
+	compressed := d.With(WithCompress(true))
+	if err := compressed.Write("keys", strings.Repeat("k", 32-len(".json.gz.tmp")), 1); err != nil {
+		t.Fatalf("compressed Write of a name at the limit returned %v", err)
+	}
+	if err := compressed.Write("keys", strings.Repeat("k", 32-len(".json.tmp")), 1); !errors.Is(err, ErrNameTooLong) {
+		t.Fatalf("compressed Write of a name over the limit returned %v, want ErrNameTooLong", err)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import "sync"
+
+// lifecycle tracks the operations in flight on a driver and its copies, so
+// Close can wait for them and turn later ones away, and Relocate can hold
+// them off. It counts rather than using a sync.RWMutex because operations
+// nest, and a nested read lock would deadlock against a waiting Close.
+type lifecycle struct {
+	mutex  sync.Mutex
+	idle   *sync.Cond
+	active int
+	closed bool
+	paused bool
+	// pausing counts the pause calls waiting for operations to finish.
+	pausing int
+}
+
+func newLifecycle() *lifecycle {
+	l := &lifecycle{}
+	l.idle = sync.NewCond(&l.mutex)
+	return l
+}
+
+// enter registers an operation, returning ErrClosed once Close has been
+// called. The operation must call leave when it is done. While a pause is
+// waiting, an operation arriving when none is in flight, which cannot be
+// nested in another, waits too, so a steady stream of operations cannot keep
+// the pause waiting for ever.
+func (d *Driver) enter() (leave func(), err error) {
+	l := d.life
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for (l.paused || l.pausing > 0 && l.active == 0) && !l.closed {
+		l.idle.Wait()
+	}
+	if l.closed {
+		return nil, ErrClosed
+	}
+	l.active++
+
+	return func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+
+		l.active--
+		if l.active == 0 {
+			l.idle.Broadcast()
+		}
+	}, nil
+}
+
+// shutdown turns away new operations and waits for those in flight. It
+// reports whether this call was the one to close the driver.
+func (l *lifecycle) shutdown() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.closed {
+		return false
+	}
+	l.closed = true
+	// Operations and pauses waiting on a pause give up with ErrClosed.
+	l.idle.Broadcast()
+
+	for l.active > 0 || l.paused {
+		l.idle.Wait()
+	}
+	return true
+}
+
+// pause waits until no operation is in flight, then holds new ones off
+// until resume is called. Unlike shutdown it turns nothing away, so it has
+// to wait for a moment between operations, and must not be called from
+// within one. It returns ErrClosed once Close has been called.
+func (l *lifecycle) pause() (resume func(), err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.pausing++
+	defer func() { l.pausing-- }()
+
+	for {
+		if l.closed {
+			return nil, ErrClosed
+		}
+		if !l.paused && l.active == 0 {
+			break
+		}
+		l.idle.Wait()
+	}
+	l.paused = true
+
+	return func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+
+		l.paused = false
+		l.idle.Broadcast()
+	}, nil
+}
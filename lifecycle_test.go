@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCloseWhileWriting(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir, WithBufferWrites(4, time.Hour))
+	copied := d.With(WithUseNumber(true))
+
+	var (
+		wg      sync.WaitGroup
+		written int64
+		errs    = make(chan error, 8)
+	)
+	for w := 0; w < 8; w++ {
+		writer := d
+		if w%2 == 1 {
+			writer = copied
+		}
+		wg.Add(1)
+		go func(w int, writer *Driver) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				if err := writer.Write("events", fmt.Sprintf("w%d-%04d", w, i), i); err != nil {
+					errs <- err
+					return
+				}
+				atomic.AddInt64(&written, 1)
+			}
+		}(w, writer)
+	}
+
+	for atomic.LoadInt64(&written) < 100 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("Write during Close returned %v, want ErrClosed", err)
+		}
+	}
+	if err := copied.Write("events", "late", 1); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Write on a copy after Close returned %v, want ErrClosed", err)
+	}
+
+	// Close waited for the writes that succeeded and flushed them.
+	keys, err := openTestDriver(t, dir).Keys("events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(keys)) != atomic.LoadInt64(&written) {
+		t.Fatalf("%d records on disk after %d successful writes", len(keys), written)
+	}
+}
+
+func TestClosedDriverReturnsErrClosed(t *testing.T) {
+	d := newTestDriver(t)
+	writeDemoUsers(t, d)
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var user User
+	_, keysErr := d.Keys("users")
+	_, modErr := d.ModTime("users", "Arnab")
+	_, existsErr := d.ExistsMany("users", []string{"Arnab"})
+	_, overviewErr := d.Overview()
+	_, metaErr := d.GetMeta("users", "Arnab")
+	_, readAllErr := d.ReadAll("users")
+	_, allKeysErr := d.AllKeys()
+	calls := map[string]error{
+		"Read":       d.Read("users", "Arnab", &user),
+		"ReadAll":    readAllErr,
+		"Write":      d.Write("users", "Arnab", User{Name: "Arnab"}),
+		"Delete":     d.Delete("users", "Arnab"),
+		"Keys":       keysErr,
+		"ModTime":    modErr,
+		"ExistsMany": existsErr,
+		"Overview":   overviewErr,
+		"GetMeta":    metaErr,
+		"AllKeys":    allKeysErr,
+		"Flush":      d.Flush(),
+		"Close":      d.Close(),
+	}
+	for name, err := range calls {
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("%v after Close returned %v, want ErrClosed", name, err)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// compareAndSwap replaces the balance with to if it is still from.
+func compareAndSwap(d *Driver, from, to int) (bool, error) {
+	d, unlock := d.Lock("accounts", "alice")
+	defer unlock()
+
+	var balance int
+	if err := d.Read("accounts", "alice", &balance); err != nil {
+		return false, err
+	}
+	if balance != from {
+		return false, nil
+	}
+	return true, d.Write("accounts", "alice", to)
+}
+
+func TestLockCompareAndSwap(t *testing.T) {
+	d := newTestDriver(t)
+	if err := d.Write("accounts", "alice", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	swaps := 0
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var balance int
+				if err := d.Read("accounts", "alice", &balance); err != nil {
+					t.Error(err)
+					return
+				}
+				if balance >= 100 {
+					return
+				}
+				ok, err := compareAndSwap(d, balance, balance+1)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if ok {
+					mutex.Lock()
+					swaps++
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var balance int
+	if err := d.Read("accounts", "alice", &balance); err != nil {
+		t.Fatal(err)
+	}
+	if balance != 100 || swaps != 100 {
+		t.Fatalf("balance %d after %d swaps, want 100 after 100", balance, swaps)
+	}
+}
+
+func TestLockExcludesWriteAndDelete(t *testing.T) {
+	d := newTestDriver(t)
+	if err := d.Write("accounts", "alice", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	locked, unlock := d.Lock("accounts", "alice")
+
+	wrote, deleted := make(chan error), make(chan error)
+	go func() { wrote <- d.Write("accounts", "alice", 99) }()
+	go func() { deleted <- d.Delete("accounts", "alice") }()
+
+	// The holder writes through locked while the others wait.
+	if err := locked.Write("accounts", "alice", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("accounts", "bob", 1); err != nil {
+		t.Fatalf("Write of another record under the lock returned %v", err)
+	}
+	select {
+	case err := <-wrote:
+		t.Fatalf("Write returned %v while the record was locked", err)
+	case err := <-deleted:
+		t.Fatalf("Delete returned %v while the record was locked", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	var balance int
+	if err := d.Read("accounts", "alice", &balance); err != nil || balance != 1 {
+		t.Fatalf("Read under the lock returned %d, %v; want 1", balance, err)
+	}
+
+	unlock()
+	for _, done := range []chan error{wrote, deleted} {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLockAfterClose(t *testing.T) {
+	d := newTestDriver(t)
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, unlock := d.Lock("accounts", "alice")
+	unlock()
+	unlock()
+	if err := d.Write("accounts", "alice", 1); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Write after Close returned %v, want ErrClosed", err)
+	}
+}
+
+func TestSharedLocksAcrossDrivers(t *testing.T) {
+	dir := t.TempDir()
+	locks := NewLocks()
+	a := openTestDriver(t, dir, WithLocks(locks))
+	b := openTestDriver(t, dir, WithLocks(locks), WithLockFreeReads(true))
+
+	if a.getOrCreateMutex("counters") != b.getOrCreateMutex("counters") {
+		t.Fatal("drivers sharing Locks have different collection locks")
+	}
+
+	var wg sync.WaitGroup
+	for _, d := range []*Driver{a, b, a, b} {
+		wg.Add(1)
+		go func(d *Driver) {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				locked, unlock := d.Lock("counters", "hits")
+				var n int
+				if err := locked.Read("counters", "hits", &n); err != nil && !errors.Is(err, ErrNotFound) {
+					t.Error(err)
+				}
+				if err := locked.Write("counters", "hits", n+1); err != nil {
+					t.Error(err)
+				}
+				unlock()
+			}
+		}(d)
+	}
+	wg.Wait()
+
+	var n int
+	if err := a.Read("counters", "hits", &n); err != nil || n != 100 {
+		t.Fatalf("counter = %d, %v; want 100", n, err)
+	}
+}
@@ -1,14 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
 	"sync"
-
-	"github.com/jcelliott/lumber"
+	"syscall"
+	"time"
 )
 
 const Version = "1.0.0"
@@ -24,162 +32,1245 @@ type (
 	}
 
 	Driver struct {
-		mutex   sync.Mutex
-		mutexes map[string]*sync.Mutex
-		dir     string
-		log     Logger
+		mutex *sync.Mutex
+		locks *Locks
+		files chan struct{}
+		known map[string]bool
+		// fanout counts the files in each collection directory for
+		// Options.DirSizeWarnThreshold, or holds -1 once it has warned.
+		fanout map[string]int
+		// buffer is nil unless Options.BufferWrites is set, and shared by
+		// copies made with With.
+		buffer *writeBuffer
+		// cache is nil unless Options.CacheSize is set, and shared by
+		// copies made with With.
+		cache *readCache
+		// flights is nil unless Options.CoalesceReads is set, and shared
+		// by copies made with With.
+		flights *readFlights
+		// syncer is nil unless Options.SyncInterval is set, and shared by
+		// copies made with With.
+		syncer *syncer
+		// life is shared by copies made with With, so closing any of them
+		// closes them all.
+		life *lifecycle
+		// dir is shared by copies made with With, so Relocate moves them
+		// all.
+		dir *string
+		// held lists the record locks the driver Lock returned holds.
+		held []recordKey
+		log  Logger
+		opts Options
 	}
 
 	Options struct {
 		Logger
+
+		// DryRun makes Migrate, Delete, ReplaceCollection, Compact,
+		// Recover and Archive log the files they would rewrite or remove
+		// instead of touching disk.
+		DryRun bool
+
+		// TrackOverwrites makes WriteEx report whether it replaced an
+		// existing record, at the cost of an extra stat per write.
+		TrackOverwrites bool
+
+		// NoTrailingNewline stops Write from terminating records with '\n'.
+		NoTrailingNewline bool
+
+		// ReadOnly rejects every operation that would modify disk with
+		// ErrReadOnly.
+		ReadOnly bool
+
+		// UseNumber decodes numbers into interface{} values as json.Number
+		// rather than float64, so large integers keep their precision.
+		UseNumber bool
+
+		// OperationTimeout bounds how long Write, Read, ReadAll and Delete
+		// may take, including waiting for the collection lock, before they
+		// give up with ErrTimeout. Zero means no timeout.
+		OperationTimeout time.Duration
+
+		// FollowSymlinks lets records that are symlinks be read and written
+		// through to their targets. Without it they are refused with
+		// ErrInvalidName. Symlinked collection directories always work.
+		FollowSymlinks bool
+
+		// Layout selects how collections are stored on disk. It applies to the
+		// whole database and must not change once data has been written.
+		Layout Layout
+
+		// WarnUnknownCollection logs a warning the first time a write creates
+		// a collection that did not exist yet, to catch typos in names.
+		WarnUnknownCollection bool
+
+		// PathFor overrides where a PerFile record is stored, returning its
+		// slash-separated path relative to the database directory, without
+		// the extension. The default is "collection/resource". The path must
+		// stay inside the collection's directory and end in the resource name,
+		// e.g. "users/ar/arnab" to shard by prefix; records it puts anywhere
+		// else are refused with ErrInvalidName. Changing the scheme makes
+		// records written under the old one unreachable.
+		PathFor func(collection, resource string) string
+
+		// DisallowUnknownFields makes decoding a record into a struct fail
+		// when the record has a field the struct does not define.
+		DisallowUnknownFields bool
+
+		// LockFreeReads lets Read skip the collection lock. Writes replace
+		// records with an atomic rename, so a read can only ever miss a
+		// record mid-replace; Read retries a few times before reporting
+		// ErrNotFound.
+		LockFreeReads bool
+
+		// Compress makes Write compress PerFile records with Compressor
+		// and store them as <resource>.json.gz, or whichever extension
+		// the codec uses. Records are found under any of the extensions
+		// whatever the setting, so it can be turned on for an existing
+		// database; a record is converted the next time it is written.
+		Compress bool
+		// Compressor is the codec Compress uses, gzip if nil. Records
+		// already written with gzip stay readable under another codec.
+		Compressor Compressor
+
+		// Locks is the registry the driver takes its collection and record
+		// locks from. Drivers opened on the same directory in one process
+		// should share one, or their writes race each other; clones made
+		// with With already share their parent's. Locks do not coordinate
+		// separate processes. New creates a fresh registry when nil.
+		Locks *Locks
+
+		// MaxOpenFiles bounds how many record files the driver has open at
+		// once across all goroutines, whatever the worker count passed to
+		// ReadAllParallel, so large collections cannot exhaust file
+		// descriptors. Zero means no limit.
+		MaxOpenFiles int
+
+		// TrackChanges keeps an append-only changelog per collection,
+		// numbering every record written or deleted so Changes can report
+		// what happened after a given sequence number. Changes made while
+		// it was off are not recorded.
+		TrackChanges bool
+
+		// TempSuffix is appended to a file's name while it is being written,
+		// before it is renamed into place. It defaults to ".tmp" and must
+		// not end in a record extension. Files ending in it are treated as
+		// leftovers by Compact, Recover and Snapshot, so it should not be
+		// changed while temp files from an earlier run may remain.
+		TempSuffix string
+
+		// RejectEmpty makes Write refuse values that encode to null, {} or
+		// [] with ErrEmptyRecord, guarding against blanking a record by
+		// accident. Migrate, WriteStream and Archive check the raw
+		// records they store too.
+		RejectEmpty bool
+
+		// FallbackDir is a second copy of the database, e.g. a backup or
+		// replica, that Read turns to when a record in the primary directory
+		// cannot be read back or is not valid JSON. Records missing from the
+		// primary are not looked up there.
+		FallbackDir string
+
+		// ValidateUTF8 checks that records are valid UTF-8 when they are
+		// read and when raw bytes are written through Migrate or
+		// WriteStream, failing with ErrInvalidEncoding instead of an
+		// obscure decoding error. Encoded values are always valid UTF-8.
+		ValidateUTF8 bool
+
+		// EncodeKeys stores PerFile records under a lowercase base32
+		// encoding of their resource names, so names may hold characters
+		// such as '/' or ':' that filesystems reject, and names differing
+		// only in case stay apart. Keys, ReadAll and the other listings
+		// return the original names. Encoded names are 60% longer, and
+		// toggling the option makes existing records unreachable.
+		EncodeKeys bool
+
+		// ReadDirs are further copies of the database, e.g. on other
+		// mounts, that Read, ReadAll, ReadAllParallel, ReadAllSince, Stream
+		// and Keys consult in order after the primary directory for
+		// records it does not have; other operations only see the primary. Writes and deletes only
+		// ever touch the primary, so a record deleted there can still be
+		// read from a read dir, and one written there shadows older copies
+		// elsewhere. Keeping the copies in sync is up to the caller.
+		ReadDirs []string
+
+		// Validator checks every value Write, UpsertMany, ReplaceCollection
+		// and ApplyJSONPatch are about to store, given its encoded form,
+		// and the raw records Migrate, WriteStream and Archive store.
+		// An error rejects the write and is returned as a
+		// *ValidationError, so a validator should return one itself to
+		// report several issues at once.
+		Validator func(collection, resource string, raw []byte) error
+
+		// BufferWrites makes Write stage records in memory instead of
+		// writing them out, until Flush is called or one of the limits
+		// below is reached. Staged records are visible to this driver and
+		// its copies, but not to other drivers on the same directory, and
+		// are lost if the process exits before they are flushed. Snapshot,
+		// Backup and other operations that copy files only see what has
+		// been flushed.
+		BufferWrites bool
+		// BufferMaxRecords flushes once this many records are staged. 0
+		// means no limit.
+		BufferMaxRecords int
+		// BufferMaxDelay flushes this long after the first record is
+		// staged. 0 means no limit.
+		BufferMaxDelay time.Duration
+
+		// CacheSize keeps up to this many recently read records in memory,
+		// so Read and ReadRaw of a hot record skip the disk. Writes and
+		// deletes through this driver and its copies invalidate what they
+		// touch, but changes made by other drivers or processes are not
+		// seen until the record falls out of the cache. 0 disables it.
+		CacheSize int
+
+		// CoalesceReads lets concurrent Reads and ReadRaws of the same
+		// record that miss the cache share a single read from disk; each
+		// caller still decodes into its own value. A read starting after
+		// a write through this driver never shares with one from before.
+		CoalesceReads bool
+
+		// DirSizeWarnThreshold logs a warning, once per collection, when a
+		// write takes a collection directory past this many files, as
+		// large directories slow down on some filesystems and PathFor can
+		// shard them. Collections already sharded by PathFor and the
+		// SingleFile layout are not checked. 0 disables it.
+		DirSizeWarnThreshold int
+
+		// SyncInterval makes writes durable against power loss, lazily.
+		// Every write goes to a temp file renamed into place, which
+		// survives the process crashing but not necessarily the machine
+		// losing power. With SyncInterval set, each file written and the
+		// directory holding it are also fsynced, in the background about
+		// once per interval, and whenever Flush or Close is called. A
+		// power loss can then lose at most the writes of the last
+		// interval. Deletes and renames of whole collections are not
+		// synced. 0 leaves writes unsynced.
+		SyncInterval time.Duration
+
+		// IDGenerator names the records Insert writes, e.g. with ULIDs or
+		// a counter. It must be safe for concurrent use. Defaults to
+		// random UUIDs.
+		IDGenerator func() string
+
+		// RateLimiter, if set, is waited on by Read and once per record
+		// by Write, WriteIfAbsent, Insert, UpsertMany, WriteMulti,
+		// WriteStream and WriteBatchContext, e.g. a *rate.Limiter from
+		// golang.org/x/time/rate, so a busy workload cannot saturate a
+		// shared disk. CollectionRateLimits adds limits for single
+		// collections, waited on as well.
+		RateLimiter          RateLimiter
+		CollectionRateLimits map[string]RateLimiter
+
+		// MaxNameLength is the longest file name, in bytes, writes may
+		// create, so a resource name too long for the filesystem fails
+		// with ErrNameTooLong rather than an obscure error from the OS.
+		// It covers the record's temp file, which is the longest: the
+		// name, its extension and TempSuffix. Defaults to 255.
+		MaxNameLength int
+
+		// EncryptFields lists, by collection, top-level string fields
+		// stored encrypted with AES-GCM under EncryptionKey, which must
+		// then be 16, 24 or 32 bytes long. The rest of a record stays
+		// plaintext, and reads decrypt them again. Only writes encrypt,
+		// so records stored before a field was listed read as they are
+		// until rewritten. A sealed value is bound to its collection,
+		// resource and field: copied anywhere else, including by
+		// RenameCollection or CopyCollection, it no longer decrypts.
+		EncryptFields map[string][]string
+		EncryptionKey []byte
+	}
+
+	WriteResult struct {
+		// Overwritten is only populated when Options.TrackOverwrites is set.
+		Overwritten bool
 	}
 )
 
-func New(dir string, options *Options) (*Driver, error) {
+// New opens the database at dir. It accepts either functional options such
+// as WithLogger or a single *Options, which is applied wholesale. Unless
+// Options.ReadOnly is set, dir is created if it does not exist, and New
+// returns ErrNotWritable if files cannot be created in it.
+func New(dir string, options ...Option) (*Driver, error) {
 	dir = filepath.Clean(dir)
 
 	opts := Options{}
 
-	if options != nil {
-		opts = *options
+	for _, option := range options {
+		if option != nil {
+			option.apply(&opts)
+		}
 	}
 
-	if opts.Logger == nil {
-		opts.Logger = lumber.NewConsoleLogger((lumber.INFO))
+	if err := opts.normalize(dir); err != nil {
+		return nil, err
 	}
 
 	driver := Driver{
-		dir:     dir,
-		mutexes: make(map[string]*sync.Mutex),
-		log:     opts.Logger,
+		dir:    &dir,
+		mutex:  &sync.Mutex{},
+		locks:  opts.Locks,
+		known:  make(map[string]bool),
+		fanout: make(map[string]int),
+		files:  openFileLimit(opts.MaxOpenFiles),
+		log:    opts.Logger,
+		opts:   opts,
+		life:   newLifecycle(),
+	}
+	if opts.BufferWrites {
+		driver.buffer = newWriteBuffer()
+	}
+	if opts.CacheSize > 0 {
+		driver.cache = newReadCache(opts.CacheSize)
+	}
+	if opts.CoalesceReads {
+		driver.flights = newReadFlights()
 	}
 
-	if _, err := os.Stat(dir); err != nil {
-		opts.Logger.Debug("Using '%s' (database already exists)\n", dir)
+	if opts.ReadOnly {
+		opts.Logger.Debug("Using '%s' (read-only)\n", dir)
 		return &driver, nil
 	}
 
-	opts.Logger.Debug("Creating the database at '%s'...\n", dir)
+	if _, err := os.Stat(dir); err == nil {
+		opts.Logger.Debug("Using '%s' (database already exists)\n", dir)
+	} else {
+		opts.Logger.Debug("Creating the database at '%s'...\n", dir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, diskError(err)
+		}
+	}
+
+	if err := probeWritable(dir, opts.TempSuffix); err != nil {
+		return nil, err
+	}
+
+	// Started last, so no error return leaves it running.
+	if opts.SyncInterval > 0 {
+		driver.syncer = startSyncer(&driver, opts.SyncInterval)
+	}
+	return &driver, nil
+}
+
+// Close waits for the operations in flight on d and its copies, flushes the
+// writes still held by Options.BufferWrites or waiting on
+// Options.SyncInterval, and stops syncing in the background. Every later
+// operation returns ErrClosed, and so does Close itself if called again.
+func (d *Driver) Close() error {
+	if !d.life.shutdown() {
+		return ErrClosed
+	}
 
-	return &driver, os.MkdirAll(dir, 0755)
+	err := d.flushAll()
+	d.syncer.stop()
+	return err
+}
+
+// probeWritable creates and removes a marker file in dir, so a directory
+// that cannot be written is reported by New rather than by the first write.
+// The marker is hidden and carries suffix, so a marker left behind by a
+// failed removal is never mistaken for a collection.
+func probeWritable(dir, suffix string) error {
+	f, err := os.CreateTemp(dir, ".probe-*"+suffix)
+	if err != nil {
+		return fmt.Errorf("%w: %v: %w", ErrNotWritable, dir, err)
+	}
+	f.Close()
+
+	if err := os.Remove(f.Name()); err != nil {
+		return fmt.Errorf("%w: %v: %w", ErrNotWritable, dir, err)
+	}
+	return nil
 }
 
 func (d *Driver) Write(collection string, resource string, v interface{}) error {
+	_, err := d.WriteEx(collection, resource, v)
+	return err
+}
+
+// WriteEx behaves like Write but also reports what the write did.
+func (d *Driver) WriteEx(collection string, resource string, v interface{}) (WriteResult, error) {
+	return d.writeEx(context.Background(), collection, resource, v)
+}
+
+func (d *Driver) writeEx(ctx context.Context, collection string, resource string, v interface{}) (WriteResult, error) {
+	var result WriteResult
+
+	leave, err := d.enter()
+	if err != nil {
+		return result, err
+	}
+	defer leave()
+
+	if d.opts.ReadOnly {
+		return result, ErrReadOnly
+	}
 	if collection == "" {
-		return fmt.Errorf("missing collection - no place to save record")
+		return result, fmt.Errorf("missing collection - no place to save record")
 	}
 	if resource == "" {
-		return fmt.Errorf("missing resource - unable to save record (no name)")
+		return result, fmt.Errorf("missing resource - unable to save record (no name)")
+	}
+	if err := d.checkName(collection, resource); err != nil {
+		return result, err
 	}
 
-	mutex := d.getOrCreateMutex(collection)
-	mutex.Lock()
-	defer mutex.Unlock()
+	b, err := d.marshal(collection, resource, v)
+	if err != nil {
+		return result, err
+	}
 
-	dir := filepath.Join(d.dir, collection)
-	fnlPath := filepath.Join(dir, resource+".json")
-	tmpPath := fnlPath + ".tmp"
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
+	if err := d.throttle(ctx, collection); err != nil {
+		return result, err
 	}
-	b, err := json.MarshalIndent(v, "", "\t")
-	if err != nil {
+
+	var overwritten bool
+	var staged int
+
+	err = d.withTimeout(func() error {
+		defer d.lockRecord(collection, resource)()
+
+		mutex := d.getOrCreateMutex(collection)
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		d.warnIfUnknown(collection)
+
+		if d.buffer != nil {
+			overwritten, staged = d.stageWrite(collection, resource, b)
+			return nil
+		}
+
+		var err error
+		overwritten, err = d.writeRecord(collection, resource, b)
 		return err
+	})
+	if err != nil {
+		return result, err
 	}
-	b = append(b, byte('\n'))
-	if err := ioutil.WriteFile(tmpPath, b, 0644); err != nil {
-		return err
+
+	if err := d.flushIfFull(staged); err != nil {
+		return result, err
 	}
 
-	return os.Rename(tmpPath, fnlPath)
+	result.Overwritten = overwritten
+	return result, nil
 }
 
 func (d *Driver) Read(collection string, resource string, v interface{}) error {
+	return d.read(context.Background(), collection, resource, v)
+}
+
+func (d *Driver) read(ctx context.Context, collection string, resource string, v interface{}) error {
 	if collection == "" {
 		return fmt.Errorf("missing collection - unable to read")
 	}
 	if resource == "" {
 		return fmt.Errorf("missing resource - unable to read record (no name)")
 	}
+	if rv := reflect.ValueOf(v); rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("%w: got %T", ErrNotPointer, v)
+	}
 
-	record := filepath.Join(d.dir, collection, resource)
-
-	if _, err := stat(record); err != nil {
+	if err := d.throttle(ctx, collection); err != nil {
 		return err
 	}
 
-	b, err := ioutil.ReadFile(record + ".json")
+	b, err := d.readRaw(collection, resource)
 	if err != nil {
 		return err
 	}
 
-	return json.Unmarshal(b, &v)
+	if err := d.decode(b, v); err != nil {
+		return decodeError(collection, resource, b, err)
+	}
+	return nil
 }
 
-func (d *Driver) ReadAll(collection string) ([]string, error) {
-	if collection == "" {
-		return nil, fmt.Errorf("issing collection - unable to read")
+// readRaw returns the stored bytes of a record the way Read fetches them,
+// honouring Options.LockFreeReads and Options.OperationTimeout.
+func (d *Driver) readRaw(collection, resource string) ([]byte, error) {
+	leave, err := d.enter()
+	if err != nil {
+		return nil, err
 	}
+	defer leave()
 
-	dir := filepath.Join(d.dir, collection)
+	b, gen, ok := d.cache.get(collection, resource)
+	if ok {
+		return b, nil
+	}
 
-	if _, err := stat(dir); err != nil {
-		return nil, err
+	b, err = d.flights.do(collection, resource, func() ([]byte, error) {
+		return d.readUncached(collection, resource)
+	})
+	if err == nil {
+		d.cache.put(collection, resource, b, gen)
 	}
+	return b, err
+}
 
-	files, err := ioutil.ReadDir(dir)
-	if err != nil {
+func (d *Driver) readUncached(collection, resource string) ([]byte, error) {
+	var b []byte
+
+	err := d.withTimeout(func() error {
+		if d.opts.LockFreeReads {
+			var err error
+			b, err = d.readRecordLockFree(collection, resource)
+			return err
+		}
+
+		mutex := d.getOrCreateMutex(collection)
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		var err error
+		b, err = d.readRecord(collection, resource)
+		return err
+	})
+	// fn keeps running after a timeout, so b is only looked at once it
+	// has returned.
+	if errors.Is(err, ErrTimeout) {
 		return nil, err
 	}
+	if d.opts.FallbackDir != "" {
+		b, err = d.readFallback(collection, resource, b, err)
+	}
+	if errors.Is(err, ErrNotFound) {
+		for _, dir := range d.opts.ReadDirs {
+			rb, rerr := d.at(dir).readRecord(collection, resource)
+			if !errors.Is(rerr, ErrNotFound) {
+				return rb, rerr
+			}
+		}
+	}
+	return b, err
+}
+
+// at returns a view of d reading from and writing to dir instead, for
+// looking up records in Options.FallbackDir and Options.ReadDirs.
+func (d *Driver) at(dir string) *Driver {
+	view := *d
+	view.dir = &dir
+	// Staged, cached and in-flight records belong to the primary directory.
+	view.buffer = nil
+	view.cache = nil
+	view.flights = nil
+	return &view
+}
+
+// readFallback retries a record that was read from the primary directory as
+// b and err from Options.FallbackDir, if the primary copy is unreadable or
+// not valid JSON. A missing record is not retried, and the primary result
+// stands if the fallback copy is no better.
+func (d *Driver) readFallback(collection, resource string, b []byte, err error) ([]byte, error) {
+	if errors.Is(err, ErrNotFound) || err == nil && json.Valid(b) {
+		return b, err
+	}
+
+	fb, fbErr := d.at(d.opts.FallbackDir).readRecord(collection, resource)
+	if fbErr != nil || !json.Valid(fb) {
+		return b, err
+	}
+
+	d.log.Warn("Read '%s/%s' from fallback '%s'\n", collection, resource, d.opts.FallbackDir)
+	return fb, nil
+}
+
+// ReadAll returns every record in collection, sorted by resource name in
+// byte-wise lexicographic order whatever the layout and options, so the
+// order is stable from call to call. Records are not kept in insertion
+// order; for that, follow the collection's changes with Options.TrackChanges
+// and Changes.
+func (d *Driver) ReadAll(collection string) ([]string, error) {
+	if collection == "" {
+		return nil, fmt.Errorf("missing collection - unable to read")
+	}
 
 	var records []string
 
-	for _, file := range files {
-		b, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+	err := d.withTimeout(func() error {
+		resources, read, err := d.tieredRecords(collection)
 		if err != nil {
-			return nil, err
+			return err
+		}
+
+		for _, resource := range resources {
+			b, err := read(resource)
+			if err != nil {
+				return err
+			}
+			records = append(records, string(b))
 		}
-		records = append(records, string(b))
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return records, nil
 }
 
 func (d *Driver) Delete(collection, resource string) error {
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if collection == "" {
+		return fmt.Errorf("missing collection - unable to delete")
+	}
+
+	return d.withTimeout(func() error {
+		return d.delete(collection, resource)
+	})
+}
+
+// DeleteIfExists is Delete for cleanup that may run more than once: it
+// reports whether the record, or the collection if resource is empty, was
+// there to remove, and only returns an error if removing it failed.
+func (d *Driver) DeleteIfExists(collection, resource string) (bool, error) {
+	if d.opts.ReadOnly {
+		return false, ErrReadOnly
+	}
+	if collection == "" {
+		return false, fmt.Errorf("missing collection - unable to delete")
+	}
+
+	// fn keeps running after a timeout, so its result is only looked at
+	// once it has returned.
+	var removed bool
+
+	err := d.withTimeout(func() error {
+		mutex := d.getOrCreateMutex(collection)
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if resource == "" {
+			if !d.collectionExists(collection) {
+				return nil
+			}
+		} else if _, err := d.readRecord(collection, resource); errors.Is(err, ErrNotFound) {
+			return nil
+		}
+
+		if err := d.deleteLocked(collection, resource); err != nil {
+			return err
+		}
+		removed = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return removed, nil
+}
+
+func (d *Driver) delete(collection, resource string) error {
+	if resource != "" {
+		defer d.lockRecord(collection, resource)()
+	}
+
 	mutex := d.getOrCreateMutex(collection)
 	mutex.Lock()
 	defer mutex.Unlock()
 
-	dir := filepath.Join(d.dir, collection, resource)
+	return d.deleteLocked(collection, resource)
+}
+
+// deleteLocked is delete for a caller that holds the collection lock.
+func (d *Driver) deleteLocked(collection, resource string) error {
+	if resource != "" {
+		return d.deleteRecord(collection, resource)
+	}
+	if !d.opts.DryRun {
+		d.buffer.discard(collection, "")
+		d.forget(collection, "")
+	}
+	if d.opts.Layout == SingleFile {
+		return d.deleteFromCollectionFile(collection, resource)
+	}
 
-	switch fi, err := stat(dir); {
-	case fi == nil, err != nil:
+	// An empty resource names a collection, where the directory wins.
+	dir := filepath.Join(*d.dir, collection)
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
 		return fmt.Errorf("unable to find file or directory named %v", dir)
+	}
+
+	if d.opts.DryRun {
+		resources, err := d.resources(collection)
+		if err != nil {
+			return err
+		}
+		for _, r := range resources {
+			d.log.Info("Would delete '%s'\n", filepath.Join(dir, r+".json"))
+		}
+		return nil
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return d.logChange(collection, true, "")
+}
 
-	case fi.Mode().IsDir():
-		return os.RemoveAll(dir)
+// deleteRecord removes a single record along with its sidecars. The caller
+// must hold the collection lock.
+func (d *Driver) deleteRecord(collection, resource string) error {
+	if !d.opts.DryRun {
+		d.forget(collection, resource)
+	}
+	if !d.opts.DryRun && d.buffer.discard(collection, resource) {
+		// A record that was only ever staged has nothing on disk to remove.
+		if _, err := d.fetchRecord(collection, resource); errors.Is(err, ErrNotFound) {
+			return nil
+		}
+	}
 
-	case fi.Mode().IsRegular():
-		return os.RemoveAll(dir + ".json")
+	if d.opts.Layout == SingleFile {
+		return d.deleteFromCollectionFile(collection, resource)
+	}
+
+	// A named resource means a record, where the file wins; a directory
+	// alone is refused rather than removed as if it were a record.
+	dir := filepath.Join(*d.dir, collection, d.fileName(resource))
+
+	if path, fi, err := d.stat(collection, resource); err == nil && !fi.IsDir() {
+		if d.opts.DryRun {
+			d.log.Info("Would delete '%s'\n", path)
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		if err := d.removeSidecars(collection, resource); err != nil {
+			return err
+		}
+		return d.logChange(collection, true, resource)
+	}
+
+	if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+		return fmt.Errorf("%w: %v is a collection, not a record", ErrAmbiguousName, dir)
+	}
+	return fmt.Errorf("unable to find file or directory named %v", dir)
+}
+
+// marshal encodes v the way Write stores it.
+func (d *Driver) marshal(collection, resource string, v interface{}) ([]byte, error) {
+	b, err := json.MarshalIndent(v, "", "\t")
+	var unsupported *json.UnsupportedValueError
+	if errors.As(err, &unsupported) {
+		return nil, fmt.Errorf("%w in %v/%v: %w", ErrUnsupportedValue, collection, resource, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkRecord(collection, resource, b); err != nil {
+		return nil, err
+	}
+	return d.finishRecord(collection, resource, b)
+}
+
+// checkRecord enforces Options.RejectEmpty and Options.Validator on the
+// JSON b about to be stored as resource.
+func (d *Driver) checkRecord(collection, resource string, b []byte) error {
+	if d.opts.RejectEmpty && isEmptyJSON(b) {
+		return fmt.Errorf("%w: %v/%v would be %s", ErrEmptyRecord, collection, resource, bytes.TrimSpace(b))
+	}
+	return d.validate(collection, resource, b)
+}
+
+// finishRecord seals the fields of a checked record and ends it with '\n'
+// unless Options.NoTrailingNewline is set.
+func (d *Driver) finishRecord(collection, resource string, b []byte) ([]byte, error) {
+	b, err := d.sealFields(collection, resource, b)
+	if err != nil {
+		return nil, err
+	}
+	b = bytes.TrimRight(b, "\n")
+	if !d.opts.NoTrailingNewline {
+		b = append(b, byte('\n'))
+	}
+	return b, nil
+}
+
+// isEmptyJSON reports whether b is null, {} or [], whatever its spacing.
+func isEmptyJSON(b []byte) bool {
+	b = bytes.TrimSpace(b)
+	if string(b) == "null" {
+		return true
+	}
+	if len(b) < 2 || len(bytes.TrimSpace(b[1:len(b)-1])) > 0 {
+		return false
+	}
+	return b[0] == '{' && b[len(b)-1] == '}' || b[0] == '[' && b[len(b)-1] == ']'
+}
+
+const (
+	lockFreeReadRetries = 3
+	lockFreeReadBackoff = time.Millisecond
+)
+
+func (d *Driver) readRecordLockFree(collection, resource string) ([]byte, error) {
+	b, err := d.readRecord(collection, resource)
+	for i := 0; i < lockFreeReadRetries && errors.Is(err, ErrNotFound); i++ {
+		time.Sleep(lockFreeReadBackoff)
+		b, err = d.readRecord(collection, resource)
+	}
+	return b, err
+}
+
+// withTimeout runs fn, giving up with ErrTimeout once
+// Options.OperationTimeout elapses. fn keeps running in the background after
+// a timeout, so a write that timed out may still complete later.
+func (d *Driver) withTimeout(fn func() error) error {
+	leave, err := d.enter()
+	if err != nil {
+		return err
+	}
+
+	if d.opts.OperationTimeout <= 0 {
+		defer leave()
+		return fn()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.opts.OperationTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		defer leave()
+		done <- fn()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ErrTimeout
 	}
-	return nil
 }
 
 func (d *Driver) getOrCreateMutex(collection string) *sync.Mutex {
-	d.mutex.Lock()
-	defer d.mutex.Unlock()
-	m, ok := d.mutexes[collection]
+	d.locks.mutex.Lock()
+	defer d.locks.mutex.Unlock()
+	m, ok := d.locks.collections[collection]
 	if !ok {
 		m = &sync.Mutex{}
-		d.mutexes[collection] = m
+		d.locks.collections[collection] = m
 	}
 	return m
 }
 
-func stat(path string) (fi os.FileInfo, err error) {
-	if fi, err = os.Stat(path); os.IsNotExist(err) {
-		fi, err = os.Stat(path + ".json")
+// lockCollections locks every named collection in sorted order, so two
+// callers locking overlapping sets can never deadlock, and returns a function
+// releasing them all.
+func (d *Driver) lockCollections(collections ...string) func() {
+	sorted := append([]string(nil), collections...)
+	sort.Strings(sorted)
+
+	var mutexes []*sync.Mutex
+	for i, collection := range sorted {
+		if i > 0 && collection == sorted[i-1] {
+			continue
+		}
+		mutex := d.getOrCreateMutex(collection)
+		mutex.Lock()
+		mutexes = append(mutexes, mutex)
+	}
+
+	return func() {
+		for i := len(mutexes) - 1; i >= 0; i-- {
+			mutexes[i].Unlock()
+		}
+	}
+}
+
+// warnIfUnknown logs when collection is about to be created by a write. Each
+// collection is checked against the disk at most once per driver.
+func (d *Driver) warnIfUnknown(collection string) {
+	if !d.opts.WarnUnknownCollection {
+		return
+	}
+
+	d.mutex.Lock()
+	known := d.known[collection]
+	d.known[collection] = true
+	d.mutex.Unlock()
+
+	if known || d.collectionExists(collection) {
+		return
+	}
+	d.log.Warn("Writing to unknown collection '%s'\n", collection)
+}
+
+// warnIfCrowded is called when a write adds a file to collection, and warns
+// once the directory holds more than Options.DirSizeWarnThreshold files. The
+// directory is only listed the first time; after that new files are counted
+// as they are written, so removals are not noticed. The caller must hold the
+// collection lock.
+func (d *Driver) warnIfCrowded(collection string) {
+	d.mutex.Lock()
+	count, counted := d.fanout[collection]
+	d.mutex.Unlock()
+
+	if count < 0 {
+		return
+	}
+
+	if counted {
+		count++
+	} else {
+		f, err := os.Open(filepath.Join(*d.dir, collection))
+		if err != nil {
+			return
+		}
+		names, err := f.Readdirnames(-1)
+		f.Close()
+		if err != nil {
+			return
+		}
+		count = len(names)
+	}
+
+	if count > d.opts.DirSizeWarnThreshold {
+		d.log.Warn("Collection '%s' holds %d files, consider sharding it with PathFor\n", collection, count)
+		count = -1
+	}
+
+	d.mutex.Lock()
+	d.fanout[collection] = count
+	d.mutex.Unlock()
+}
+
+func (d *Driver) collectionExists(collection string) bool {
+	path := filepath.Join(*d.dir, collection)
+	if d.opts.Layout == SingleFile {
+		path = d.collectionFile(collection)
+	}
+
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func (d *Driver) resources(collection string) ([]string, error) {
+	resources, err := d.storedResources(collection)
+	if d.buffer == nil {
+		return resources, err
+	}
+	return d.buffer.merge(collection, resources, err)
+}
+
+// storedResources is resources without writes still held by
+// Options.BufferWrites.
+func (d *Driver) storedResources(collection string) ([]string, error) {
+	if d.opts.Layout == SingleFile {
+		records, err := d.readCollectionFile(collection)
+		if err != nil {
+			return nil, err
+		}
+		return sortedKeys(records), nil
+	}
+
+	dir := filepath.Join(*d.dir, collection)
+
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+
+	if d.opts.PathFor != nil {
+		return d.walkResources(dir)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []string
+
+	for _, file := range files {
+		if resource, ok := d.recordName(dir, file); ok {
+			resources = append(resources, resource)
+		}
+	}
+	// Directory listings sort by file name, which is not the order of the
+	// names themselves once extensions or Options.EncodeKeys come in:
+	// "a-b.json" sorts before "a.json".
+	sort.Strings(resources)
+	return resources, nil
+}
+
+// walkResources lists the records under dir and all of its subdirectories,
+// for collections sharded by Options.PathFor.
+func (d *Driver) walkResources(dir string) ([]string, error) {
+	var resources []string
+
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if resource, ok := d.recordName(filepath.Dir(path), fi); ok {
+			resources = append(resources, resource)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(resources)
+	return resources, nil
+}
+
+// recordName returns the resource stored in file, which lives in dir, or
+// false if file does not hold a record.
+func (d *Driver) recordName(dir string, file os.FileInfo) (string, bool) {
+	ext, ok := d.recordExt(file.Name())
+	if !ok || strings.HasSuffix(file.Name(), metaSuffix+ext) {
+		return "", false
+	}
+	if file.Mode()&os.ModeSymlink != 0 {
+		if !d.opts.FollowSymlinks {
+			return "", false
+		}
+
+		var err error
+		if file, err = os.Stat(filepath.Join(dir, file.Name())); err != nil {
+			return "", false
+		}
+	}
+	if !file.Mode().IsRegular() {
+		return "", false
+	}
+	return d.resourceName(strings.TrimSuffix(file.Name(), ext))
+}
+
+func (d *Driver) decode(b []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if d.opts.UseNumber {
+		dec.UseNumber()
+	}
+	if d.opts.DisallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("invalid data after top-level JSON value")
+	}
+	return nil
+}
+
+// decodeError says which record failed to decode and, for malformed JSON,
+// the byte offset where decoding stopped.
+func decodeError(collection, resource string, b []byte, err error) error {
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return fmt.Errorf("unable to decode %v/%v at offset %d: %w", collection, resource, syntaxErr.Offset, err)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return fmt.Errorf("unable to decode %v/%v at offset %d: %w", collection, resource, len(b), err)
+	}
+	return fmt.Errorf("unable to decode %v/%v: %w", collection, resource, err)
+}
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+func openFileLimit(max int) chan struct{} {
+	if max <= 0 {
+		return nil
+	}
+	return make(chan struct{}, max)
+}
+
+// acquireFile waits until opening another file stays within
+// Options.MaxOpenFiles and returns the function to call once it is closed.
+func (d *Driver) acquireFile() (release func()) {
+	if d.files == nil {
+		return func() {}
+	}
+	d.files <- struct{}{}
+	return func() { <-d.files }
+}
+
+func (d *Driver) readFile(path string) ([]byte, error) {
+	if !d.opts.FollowSymlinks {
+		if _, err := d.resolve(path); err != nil {
+			return nil, err
+		}
+	}
+
+	release := d.acquireFile()
+	b, err := ioutil.ReadFile(path)
+	release()
+	if err != nil {
+		return nil, err
+	}
+	// Gzip is recognised by its content rather than the extension, so such
+	// a record is readable whichever way it ended up stored; other codecs
+	// go by the extension.
+	codec := d.codecFor(path)
+	if isGzip(b) {
+		codec = gzipCompressor{}
+	}
+	if codec != nil {
+		if b, err = decompressBytes(codec, b); err != nil {
+			return nil, fmt.Errorf("unable to decompress %v: %w", path, err)
+		}
+	}
+	return bytes.TrimPrefix(b, utf8BOM), nil
+}
+
+func (d *Driver) writeFile(path string, b []byte) error {
+	path, tmpPath, err := d.stageFile(path, b)
+	if err != nil {
+		return err
+	}
+	if err := replaceFile(tmpPath, path); err != nil {
+		return err
+	}
+	d.syncer.markDirty(path)
+	return nil
+}
+
+// removeBeforeRename is set where a rename can fail because its target
+// exists, as some renames do on Windows.
+var removeBeforeRename = runtime.GOOS == "windows"
+
+// replaceFile renames tmpPath over path. A failed rename removes tmpPath, as
+// best it can, and is wrapped in ErrWriteFailed. Under removeBeforeRename the
+// file is removed and the rename retried first.
+func replaceFile(tmpPath, path string) error {
+	err := os.Rename(tmpPath, path)
+	if err != nil && removeBeforeRename {
+		if rerr := os.Remove(path); rerr == nil || os.IsNotExist(rerr) {
+			err = os.Rename(tmpPath, path)
+		}
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("%w: unable to move %v into place: %w", ErrWriteFailed, path, diskError(err))
+	}
+	return nil
+}
+
+// stageFile writes b to a temp file, ready to be renamed over the path it
+// returns, which is path with any permitted symlink resolved.
+func (d *Driver) stageFile(path string, b []byte) (string, string, error) {
+	path, err := d.resolve(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	if codec := d.codecFor(path); codec != nil {
+		if b, err = compressBytes(codec, b); err != nil {
+			return "", "", err
+		}
+	}
+
+	release := d.acquireFile()
+	defer release()
+
+	tmpPath := path + d.opts.TempSuffix
+	if err := ioutil.WriteFile(tmpPath, b, 0644); err != nil {
+		return "", "", diskError(err)
+	}
+	return path, tmpPath, nil
+}
+
+// writeStream is writeFile for content that fill writes out piece by piece,
+// so it never has to be held in memory.
+func (d *Driver) writeStream(path string, fill func(w io.Writer) error) error {
+	path, err := d.resolve(path)
+	if err != nil {
+		return err
+	}
+
+	release := d.acquireFile()
+	defer release()
+
+	tmpPath := path + d.opts.TempSuffix
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return diskError(err)
+	}
+
+	if codec := d.codecFor(path); codec != nil {
+		w := codec.Compress(f)
+		err = fill(w)
+		if err == nil {
+			err = w.Close()
+		}
+	} else {
+		err = fill(f)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return diskError(err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return diskError(err)
+	}
+
+	if err := replaceFile(tmpPath, path); err != nil {
+		return err
+	}
+	d.syncer.markDirty(path)
+	return nil
+}
+
+func (d *Driver) collections() ([]string, error) {
+	files, err := ioutil.ReadDir(*d.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var collections []string
+
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		if d.opts.Layout == SingleFile {
+			if file.Mode().IsRegular() && filepath.Ext(file.Name()) == ".json" {
+				collections = append(collections, strings.TrimSuffix(file.Name(), ".json"))
+			}
+			continue
+		}
+		if file.Mode()&os.ModeSymlink != 0 {
+			if file, err = os.Stat(filepath.Join(*d.dir, file.Name())); err != nil {
+				continue
+			}
+		}
+		if file.IsDir() {
+			collections = append(collections, file.Name())
+		}
+	}
+	return collections, nil
+}
+
+// resolve returns the file to operate on for a record stored at path. A
+// record that is a symlink is refused with ErrInvalidName unless
+// Options.FollowSymlinks is set, in which case its target is returned.
+func (d *Driver) resolve(path string) (string, error) {
+	fi, err := os.Lstat(path)
+	if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		return path, nil
+	}
+	if !d.opts.FollowSymlinks {
+		return "", fmt.Errorf("%w: %v is a symlink", ErrInvalidName, path)
+	}
+	return filepath.EvalSymlinks(path)
+}
+
+func diskError(err error) error {
+	if errors.Is(err, syscall.ENOSPC) {
+		return fmt.Errorf("%w: %w", ErrDiskFull, err)
 	}
-	return
+	return err
 }
 
 type Address struct {
@@ -0,0 +1,890 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testLogger discards everything logged but keeps the warnings and info
+// messages, so tests can check them.
+type testLogger struct {
+	mutex    sync.Mutex
+	warnings []string
+	infos    []string
+}
+
+func (l *testLogger) Fatal(string, ...interface{}) {}
+func (l *testLogger) Error(string, ...interface{}) {}
+func (l *testLogger) Debug(string, ...interface{}) {}
+func (l *testLogger) Trace(string, ...interface{}) {}
+
+func (l *testLogger) Warn(format string, args ...interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.warnings = append(l.warnings, strings.TrimSpace(fmt.Sprintf(format, args...)))
+}
+
+func (l *testLogger) Warnings() []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]string(nil), l.warnings...)
+}
+
+func (l *testLogger) Info(format string, args ...interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.infos = append(l.infos, strings.TrimSpace(fmt.Sprintf(format, args...)))
+}
+
+func (l *testLogger) Infos() []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]string(nil), l.infos...)
+}
+
+// newTestDriver opens a database in a fresh temp dir that logs nowhere.
+func newTestDriver(t testing.TB, options ...Option) *Driver {
+	t.Helper()
+	return openTestDriver(t, t.TempDir(), options...)
+}
+
+// openTestDriver opens the database at dir that logs nowhere, and closes it
+// when the test ends.
+func openTestDriver(t testing.TB, dir string, options ...Option) *Driver {
+	t.Helper()
+
+	d, err := New(dir, append([]Option{WithLogger(&testLogger{})}, options...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+// mustWrite writes records, failing the test on the first error.
+func mustWrite(t testing.TB, d *Driver, collection string, records map[string]interface{}) {
+	t.Helper()
+	for resource, v := range records {
+		if err := d.Write(collection, resource, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func demoUsers() []User {
+	return []User{
+		{Name: "Arnab", Age: "29", Contact: "322444566", Company: "DAPL", Address: Address{City: "Kolkata", State: "W.B.", Country: "India", PinCode: "755855"}},
+		{Name: "John", Age: "23", Contact: "322444564", Company: "Microsoft", Address: Address{City: "Bangalore", State: "Karnataka", Country: "India", PinCode: "400014"}},
+		{Name: "Harry", Age: "25", Contact: "322444567", Company: "Google", Address: Address{City: "Hyderabad", State: "Telangana", Country: "India", PinCode: "500019"}},
+		{Name: "Paul", Age: "27", Contact: "422444567", Company: "Adobe", Address: Address{City: "Mumbai", State: "Maharastra", Country: "India", PinCode: "485669"}},
+		{Name: "Rahul", Age: "28", Contact: "453444567", Company: "IBM", Address: Address{City: "Pune", State: "Maharastra", Country: "India", PinCode: "610019"}},
+		{Name: "Jane", Age: "26", Contact: "453341567", Company: "Twilio", Address: Address{City: "Bangalore", State: "Karnataka", Country: "India", PinCode: "400017"}},
+	}
+}
+
+// writeDemoUsers stores demoUsers in the users collection, keyed by name.
+func writeDemoUsers(t testing.TB, d *Driver) {
+	t.Helper()
+	for _, user := range demoUsers() {
+		if err := d.Write("users", user.Name, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// decodeAll decodes the records ReadAll returns into T.
+func decodeAll[T any](t testing.TB, records []string) []T {
+	t.Helper()
+
+	all := make([]T, 0, len(records))
+	for _, record := range records {
+		var v T
+		if err := json.Unmarshal([]byte(record), &v); err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, v)
+	}
+	return all
+}
+
+func TestWriteExOverwritten(t *testing.T) {
+	d := newTestDriver(t, WithTrackOverwrites(true))
+
+	result, err := d.WriteEx("users", "Arnab", demoUsers()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Overwritten {
+		t.Fatal("creating a record reported Overwritten")
+	}
+
+	result, err = d.WriteEx("users", "Arnab", demoUsers()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Overwritten {
+		t.Fatal("replacing a record did not report Overwritten")
+	}
+}
+
+func TestWriteExUntracked(t *testing.T) {
+	d := newTestDriver(t)
+	writeDemoUsers(t, d)
+
+	result, err := d.WriteEx("users", "Arnab", demoUsers()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Overwritten {
+		t.Fatal("Overwritten reported without TrackOverwrites")
+	}
+}
+
+func TestReadBOMAndCRLF(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+	if err := d.EnsureCollection("users"); err != nil {
+		t.Fatal(err)
+	}
+
+	record := "\xef\xbb\xbf{\"Name\": \"Arnab\",\r\n\"Company\": \"DAPL\"}\r\n"
+	if err := os.WriteFile(filepath.Join(dir, "users", "Arnab.json"), []byte(record), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var user User
+	if err := d.Read("users", "Arnab", &user); err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "Arnab" || user.Company != "DAPL" {
+		t.Fatalf("read %+v", user)
+	}
+}
+
+func TestTrailingNewline(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		dir := t.TempDir()
+		d := openTestDriver(t, dir, WithTrailingNewline(enabled))
+		if err := d.Write("users", "Arnab", demoUsers()[0]); err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := os.ReadFile(filepath.Join(dir, "users", "Arnab.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.HasSuffix(string(b), "\n"); got != enabled {
+			t.Errorf("WithTrailingNewline(%v) wrote %q", enabled, b)
+		}
+
+		var user User
+		if err := d.Read("users", "Arnab", &user); err != nil || user.Name != "Arnab" {
+			t.Errorf("WithTrailingNewline(%v): read %+v, %v", enabled, user, err)
+		}
+	}
+}
+
+func TestDeleteDryRun(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+	writeDemoUsers(t, d)
+
+	logger := &testLogger{}
+	dry := d.With(WithLogger(logger), WithDryRun(true))
+	if err := dry.Delete("users", "Arnab"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dry.Delete("users", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	infos := logger.Infos()
+	if len(infos) != 1+len(demoUsers()) {
+		t.Fatalf("dry run logged %q, want Arnab then every user", infos)
+	}
+	if want := fmt.Sprintf("Would delete '%s'", filepath.Join(dir, "users", "Arnab.json")); infos[0] != want {
+		t.Errorf("dry run logged %q, want %q", infos[0], want)
+	}
+	for _, user := range demoUsers() {
+		if _, err := os.Stat(filepath.Join(dir, "users", user.Name+".json")); err != nil {
+			t.Errorf("dry run removed %v: %v", user.Name, err)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	writeDemoUsers(t, openTestDriver(t, dir))
+	before, err := os.ReadFile(filepath.Join(dir, "users", "Arnab.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := openTestDriver(t, dir, WithReadOnly(true))
+	attempts := map[string]error{
+		"Write":    d.Write("users", "Arnab", User{Name: "Changed"}),
+		"Delete":   d.Delete("users", "Arnab"),
+		"SetField": d.SetField("users", "Arnab", "Name", "Changed"),
+		"Migrate":  d.Migrate("users", func(b []byte) ([]byte, error) { return b, nil }),
+	}
+	for name, err := range attempts {
+		if !errors.Is(err, ErrReadOnly) {
+			t.Errorf("%v returned %v, want ErrReadOnly", name, err)
+		}
+	}
+
+	after, err := os.ReadFile(filepath.Join(dir, "users", "Arnab.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		t.Fatalf("read-only driver changed the record to %s", after)
+	}
+
+	var user User
+	if err := d.Read("users", "Arnab", &user); err != nil || user.Name != "Arnab" {
+		t.Fatalf("Read returned %+v, %v", user, err)
+	}
+	records, err := d.ReadAll("users")
+	if err != nil || len(records) != len(demoUsers()) {
+		t.Fatalf("ReadAll returned %d records, %v", len(records), err)
+	}
+}
+
+func TestReadOnlyDoesNotCreateDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	if _, err := New(dir, WithLogger(&testLogger{}), WithReadOnly(true)); err == nil {
+		t.Fatal("read-only New opened a database that does not exist")
+	}
+
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("read-only New created %v: %v", dir, err)
+	}
+}
+
+func TestNotWritable(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0o500); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(dir, 0o700) })
+	if probeWritable(dir, "") == nil {
+		t.Skip("permissions are not enforced for this user")
+	}
+
+	_, err := New(dir, WithLogger(&testLogger{}))
+	if !errors.Is(err, ErrNotWritable) || !strings.Contains(err.Error(), dir) {
+		t.Fatalf("New on a read-only directory returned %v, want ErrNotWritable", err)
+	}
+
+	d, err := New(dir, WithLogger(&testLogger{}), WithReadOnly(true))
+	if err != nil {
+		t.Fatalf("read-only New on a read-only directory returned %v", err)
+	}
+	d.Close()
+}
+
+func TestDiskErrorENOSPC(t *testing.T) {
+	cause := &os.PathError{Op: "write", Path: "users/Arnab.json.tmp", Err: syscall.ENOSPC}
+
+	err := diskError(cause)
+	if !errors.Is(err, ErrDiskFull) {
+		t.Fatalf("diskError(ENOSPC) = %v, want ErrDiskFull", err)
+	}
+	var pathErr *os.PathError
+	if !errors.As(err, &pathErr) || pathErr != cause {
+		t.Fatalf("diskError(ENOSPC) = %v, lost the original error", err)
+	}
+
+	other := &os.PathError{Op: "write", Path: "users/Arnab.json.tmp", Err: syscall.EACCES}
+	if err := diskError(other); errors.Is(err, ErrDiskFull) {
+		t.Fatalf("diskError(EACCES) = %v, want no ErrDiskFull", err)
+	}
+}
+
+func TestSnapshotDiskFull(t *testing.T) {
+	// /dev/full fails every write with ENOSPC.
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("no /dev/full")
+	}
+	d := newTestDriver(t)
+	writeDemoUsers(t, d)
+
+	dest := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dest, "users"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/dev/full", filepath.Join(dest, "users", "Arnab.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Snapshot(dest); !errors.Is(err, ErrDiskFull) {
+		t.Fatalf("Snapshot onto a full disk returned %v, want ErrDiskFull", err)
+	}
+}
+
+func TestRenameFailure(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+	writeDemoUsers(t, d)
+
+	// A directory that is not empty cannot be renamed over.
+	target := filepath.Join(dir, "users", "Arnab.json")
+	if err := os.Remove(target); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(target, "child"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	err := d.Write("users", "Arnab", User{Name: "Arnab"})
+	if !errors.Is(err, ErrWriteFailed) || !strings.Contains(err.Error(), target) {
+		t.Fatalf("Write over a directory returned %v, want ErrWriteFailed", err)
+	}
+	if _, err := os.Stat(target + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("failed rename left its temp file: %v", err)
+	}
+}
+
+func TestRenameRemovesTarget(t *testing.T) {
+	defer func(remove bool) { removeBeforeRename = remove }(removeBeforeRename)
+	removeBeforeRename = true
+
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+	writeDemoUsers(t, d)
+
+	// Renaming over an empty directory fails the way Windows fails to
+	// rename over a file, and removing it lets the rename through.
+	target := filepath.Join(dir, "users", "Arnab.json")
+	if err := os.Remove(target); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Write("users", "Arnab", User{Name: "Arnab", Company: "Acme"}); err != nil {
+		t.Fatal(err)
+	}
+	var user User
+	if err := d.Read("users", "Arnab", &user); err != nil || user.Company != "Acme" {
+		t.Fatalf("Read after the replacing write returned %+v, %v", user, err)
+	}
+}
+
+// blockingCompressor stores records as they are, except Decompress waits
+// until release is closed, standing in for a wedged filesystem.
+type blockingCompressor struct {
+	release chan struct{}
+}
+
+func (blockingCompressor) Ext() string { return ".slow" }
+
+func (blockingCompressor) Compress(w io.Writer) io.WriteCloser { return nopWriteCloser{w} }
+
+func (c blockingCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	<-c.release
+	return r, nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestOperationTimeoutBlockedRead(t *testing.T) {
+	codec := blockingCompressor{release: make(chan struct{})}
+	d := newTestDriver(t, WithCompress(true), WithCompressor(codec), WithOperationTimeout(20*time.Millisecond))
+	t.Cleanup(func() { close(codec.release) })
+
+	if err := d.Write("users", "Arnab", demoUsers()[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	var user User
+	if err := d.Read("users", "Arnab", &user); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Read of a blocked record returned %v, want ErrTimeout", err)
+	}
+}
+
+func TestOperationTimeoutLockWait(t *testing.T) {
+	d := newTestDriver(t, WithOperationTimeout(20*time.Millisecond))
+
+	mutex := d.getOrCreateMutex("users")
+	mutex.Lock()
+	t.Cleanup(mutex.Unlock)
+
+	if err := d.Write("users", "Arnab", demoUsers()[0]); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Write behind a held lock returned %v, want ErrTimeout", err)
+	}
+}
+
+func TestOperationTimeoutFast(t *testing.T) {
+	d := newTestDriver(t, WithOperationTimeout(time.Second))
+	writeDemoUsers(t, d)
+
+	var user User
+	if err := d.Read("users", "Arnab", &user); err != nil || user.Name != "Arnab" {
+		t.Fatalf("Read returned %+v, %v", user, err)
+	}
+}
+
+func TestSymlinkedCollection(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(t.TempDir(), "users")
+	if err := os.Mkdir(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, filepath.Join(dir, "users")); err != nil {
+		t.Fatal(err)
+	}
+
+	d := openTestDriver(t, dir)
+	writeDemoUsers(t, d)
+
+	if _, err := os.Stat(filepath.Join(target, "Arnab.json")); err != nil {
+		t.Fatalf("write did not go through the symlink: %v", err)
+	}
+	var user User
+	if err := d.Read("users", "Arnab", &user); err != nil || user.Name != "Arnab" {
+		t.Fatalf("Read returned %+v, %v", user, err)
+	}
+	records, err := d.ReadAll("users")
+	if err != nil || len(records) != len(demoUsers()) {
+		t.Fatalf("ReadAll returned %d records, %v", len(records), err)
+	}
+}
+
+func TestEscapingSymlinkRefused(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(t.TempDir(), "secret.json")
+	if err := os.WriteFile(secret, []byte(`{"Name": "secret"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := openTestDriver(t, dir)
+	if err := d.EnsureCollection("users"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(dir, "users", "evil.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	var user User
+	if err := d.Read("users", "evil", &user); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("Read through an escaping symlink returned %v, want ErrInvalidName", err)
+	}
+	if err := d.Write("users", "evil", User{Name: "overwritten"}); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("Write through an escaping symlink returned %v, want ErrInvalidName", err)
+	}
+
+	b, err := os.ReadFile(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"Name": "secret"}` {
+		t.Fatalf("symlink target changed to %s", b)
+	}
+}
+
+func TestFollowSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir, WithFollowSymlinks(true))
+	writeDemoUsers(t, d)
+	if err := os.Symlink("Arnab.json", filepath.Join(dir, "users", "Me.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	var user User
+	if err := d.Read("users", "Me", &user); err != nil || user.Name != "Arnab" {
+		t.Fatalf("Read through a followed symlink returned %+v, %v", user, err)
+	}
+}
+
+func TestWarnUnknownCollection(t *testing.T) {
+	dir := t.TempDir()
+	writeDemoUsers(t, openTestDriver(t, dir))
+
+	logger := &testLogger{}
+	d := openTestDriver(t, dir, WithLogger(logger), WithWarnUnknownCollection(true))
+
+	for i := 0; i < 3; i++ {
+		mustWrite(t, d, "users", map[string]interface{}{"Zed": User{Name: "Zed"}})
+		mustWrite(t, d, "uesrs", map[string]interface{}{fmt.Sprint("Zed", i): User{Name: "Zed"}})
+	}
+
+	warnings := logger.Warnings()
+	if len(warnings) != 1 || warnings[0] != "Writing to unknown collection 'uesrs'" {
+		t.Fatalf("warned %q, want one warning about uesrs", warnings)
+	}
+
+	var user User
+	if err := d.Read("uesrs", "Zed0", &user); err != nil {
+		t.Fatalf("the write to the unknown collection did not proceed: %v", err)
+	}
+}
+
+func TestLockFreeReadsRacingWriter(t *testing.T) {
+	d := newTestDriver(t, WithLockFreeReads(true))
+	if err := d.Write("counters", "hits", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= 200; i++ {
+			if err := d.Write("counters", "hits", i); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	last := 0
+	for reading := true; reading; {
+		select {
+		case <-done:
+			reading = false
+		default:
+		}
+
+		var n int
+		if err := d.Read("counters", "hits", &n); err != nil {
+			t.Errorf("lock-free Read during a rewrite returned %v", err)
+			break
+		}
+		if n < last {
+			t.Errorf("read %d after %d", n, last)
+			break
+		}
+		last = n
+	}
+	<-done
+}
+
+func TestLockFreeReadsSkipLock(t *testing.T) {
+	d := newTestDriver(t, WithLockFreeReads(true))
+	writeDemoUsers(t, d)
+
+	mutex := d.getOrCreateMutex("users")
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	var user User
+	if err := d.Read("users", "Arnab", &user); err != nil || user.Name != "Arnab" {
+		t.Fatalf("Read returned %+v, %v", user, err)
+	}
+	if err := d.Read("users", "Nobody", &user); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Read of a missing record returned %v, want ErrNotFound after retrying", err)
+	}
+}
+
+func TestDecodeErrorPosition(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+	writeDemoUsers(t, d)
+
+	for content, offset := range map[string]string{
+		`{"Name": "Har`:   "offset 13",
+		`{"Name": Harry}`: "offset 10",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, "users", "Harry.json"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		var user User
+		err := d.Read("users", "Harry", &user)
+		if err == nil {
+			t.Fatalf("Read of %q succeeded", content)
+		}
+		if msg := err.Error(); !strings.Contains(msg, "users/Harry") || !strings.Contains(msg, offset) {
+			t.Errorf("Read of %q returned %q, want the resource and %v", content, msg, offset)
+		}
+	}
+}
+
+func TestDeleteNameCollision(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+	writeDemoUsers(t, d)
+
+	// users/Arnab is both a record and a directory: the record wins.
+	nested := filepath.Join(dir, "users", "Arnab")
+	if err := os.Mkdir(nested, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete("users", "Arnab"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "users", "Arnab.json")); !os.IsNotExist(err) {
+		t.Fatalf("record still there: %v", err)
+	}
+	if _, err := os.Stat(nested); err != nil {
+		t.Fatalf("deleting the record removed the directory: %v", err)
+	}
+
+	// Only the directory is left, which a record delete must not remove.
+	if err := d.Delete("users", "Arnab"); !errors.Is(err, ErrAmbiguousName) {
+		t.Fatalf("Delete of a directory as a record returned %v, want ErrAmbiguousName", err)
+	}
+	if _, err := os.Stat(nested); err != nil {
+		t.Fatalf("directory removed: %v", err)
+	}
+
+	// Without a resource the whole collection goes.
+	if err := d.Delete("users", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "users")); !os.IsNotExist(err) {
+		t.Fatalf("collection still there: %v", err)
+	}
+}
+
+func TestDeleteMissingCollection(t *testing.T) {
+	d := newTestDriver(t)
+	writeDemoUsers(t, d)
+
+	for _, resource := range []string{"", "Arnab"} {
+		if err := d.Delete("", resource); err == nil {
+			t.Errorf("Delete with no collection and resource %q succeeded", resource)
+		}
+	}
+	if keys, err := d.Keys("users"); err != nil || len(keys) != len(demoUsers()) {
+		t.Fatalf("database holds %v, %v after Delete with no collection", keys, err)
+	}
+}
+
+func TestDeleteIfExists(t *testing.T) {
+	d := newTestDriver(t)
+	writeDemoUsers(t, d)
+
+	if removed, err := d.DeleteIfExists("users", "Arnab"); err != nil || !removed {
+		t.Fatalf("DeleteIfExists of an existing record returned %v, %v", removed, err)
+	}
+	var user User
+	if err := d.Read("users", "Arnab", &user); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Read after DeleteIfExists returned %v, want ErrNotFound", err)
+	}
+
+	for _, resource := range []string{"Arnab", "Nobody"} {
+		if removed, err := d.DeleteIfExists("users", resource); err != nil || removed {
+			t.Errorf("DeleteIfExists(%v) of a missing record returned %v, %v", resource, removed, err)
+		}
+	}
+
+	if removed, err := d.DeleteIfExists("users", ""); err != nil || !removed {
+		t.Fatalf("DeleteIfExists of the collection returned %v, %v", removed, err)
+	}
+	if removed, err := d.DeleteIfExists("users", ""); err != nil || removed {
+		t.Fatalf("DeleteIfExists of a missing collection returned %v, %v", removed, err)
+	}
+}
+
+func TestReadNotPointer(t *testing.T) {
+	d := newTestDriver(t)
+	writeDemoUsers(t, d)
+
+	var user User
+	var nilUser *User
+	for name, v := range map[string]interface{}{"value": user, "nil pointer": nilUser, "nil": nil} {
+		if err := d.Read("users", "Arnab", v); !errors.Is(err, ErrNotPointer) {
+			t.Errorf("Read into a %v returned %v, want ErrNotPointer", name, err)
+		}
+	}
+
+	if err := d.Read("users", "Arnab", &user); err != nil || user.Name != "Arnab" {
+		t.Fatalf("Read into a pointer returned %+v, %v", user, err)
+	}
+}
+
+func TestDisallowUnknownFields(t *testing.T) {
+	dir := t.TempDir()
+	writeDemoUsers(t, openTestDriver(t, dir))
+
+	// Name only knows one of the fields every user has.
+	type Name struct{ Name string }
+
+	var name Name
+	if err := openTestDriver(t, dir).Read("users", "Arnab", &name); err != nil || name.Name != "Arnab" {
+		t.Fatalf("lenient Read returned %+v, %v", name, err)
+	}
+
+	strict := openTestDriver(t, dir, WithDisallowUnknownFields(true))
+	err := strict.Read("users", "Arnab", &name)
+	if err == nil {
+		t.Fatal("strict Read accepted unknown fields")
+	}
+	if msg := err.Error(); !strings.Contains(msg, "users/Arnab") || !strings.Contains(msg, `"Age"`) {
+		t.Fatalf("strict Read returned %q, want the resource and the field", msg)
+	}
+
+	all, err := Collection[Name](strict, "users").All()
+	if err == nil {
+		t.Fatalf("strict All decoded %v", all)
+	}
+}
+
+func TestWriteInfinity(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+
+	type Reading struct{ Value float64 }
+	err := d.Write("sensors", "s1", Reading{Value: math.Inf(1)})
+	if !errors.Is(err, ErrUnsupportedValue) {
+		t.Fatalf("Write of +Inf returned %v, want ErrUnsupportedValue", err)
+	}
+	if !strings.Contains(err.Error(), "sensors/s1") {
+		t.Fatalf("error %q does not name the record", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sensors", "s1.json")); !os.IsNotExist(err) {
+		t.Fatalf("failed write left a file: %v", err)
+	}
+}
+
+func TestRejectEmpty(t *testing.T) {
+	lenient := newTestDriver(t)
+	if err := lenient.Write("users", "Nil", nil); err != nil {
+		t.Fatalf("Write of nil without RejectEmpty returned %v", err)
+	}
+
+	strict := newTestDriver(t, WithRejectEmpty(true))
+	for name, v := range map[string]interface{}{
+		"nil":          nil,
+		"empty struct": struct{}{},
+		"empty slice":  []int{},
+		"empty map":    map[string]int{},
+	} {
+		if err := strict.Write("users", "Empty", v); !errors.Is(err, ErrEmptyRecord) {
+			t.Errorf("Write of %v returned %v, want ErrEmptyRecord", name, err)
+		}
+	}
+	if err := strict.Write("users", "Zero", 0); err != nil {
+		t.Fatalf("Write of 0 returned %v", err)
+	}
+}
+
+func TestFallbackDir(t *testing.T) {
+	dir, backup := t.TempDir(), t.TempDir()
+	writeDemoUsers(t, openTestDriver(t, backup))
+
+	logger := &testLogger{}
+	d := openTestDriver(t, dir, WithLogger(logger), WithFallbackDir(backup))
+	writeDemoUsers(t, d)
+	if err := os.WriteFile(filepath.Join(dir, "users", "Arnab.json"), []byte(`{"Name": "Arn`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var user User
+	if err := d.Read("users", "Arnab", &user); err != nil || user != demoUsers()[0] {
+		t.Fatalf("Read of a corrupt record returned %+v, %v; want the fallback copy", user, err)
+	}
+	if warnings := logger.Warnings(); len(warnings) != 1 || !strings.Contains(warnings[0], "from fallback") {
+		t.Fatalf("warned %q, want the fallback logged", warnings)
+	}
+
+	// A record missing from the primary is not looked for in the fallback.
+	if err := d.Delete("users", "John"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Read("users", "John", &user); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Read of a deleted record returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestValidateUTF8(t *testing.T) {
+	dir := t.TempDir()
+	writeDemoUsers(t, openTestDriver(t, dir))
+	if err := os.WriteFile(filepath.Join(dir, "users", "Harry.json"), []byte("{\"Name\": \"Ha\xffrry\"}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := openTestDriver(t, dir, WithValidateUTF8(true))
+	var user User
+	err := d.Read("users", "Harry", &user)
+	if !errors.Is(err, ErrInvalidEncoding) || !strings.Contains(err.Error(), "users/Harry") {
+		t.Fatalf("Read of invalid UTF-8 returned %v, want ErrInvalidEncoding naming the record", err)
+	}
+	if err := d.Read("users", "Arnab", &user); err != nil {
+		t.Fatalf("Read of a valid record returned %v", err)
+	}
+
+	err = d.WriteStream("users", "Bad", strings.NewReader("\"\xfe\""))
+	if !errors.Is(err, ErrInvalidEncoding) {
+		t.Fatalf("WriteStream of invalid UTF-8 returned %v, want ErrInvalidEncoding", err)
+	}
+}
+
+func TestDirSizeWarnThreshold(t *testing.T) {
+	logger := &testLogger{}
+	d := newTestDriver(t, WithLogger(logger), WithDirSizeWarnThreshold(5))
+
+	for i := 0; i < 5; i++ {
+		mustWrite(t, d, "events", map[string]interface{}{fmt.Sprint("e", i): i})
+	}
+	// Overwrites add no files.
+	for i := 0; i < 5; i++ {
+		mustWrite(t, d, "events", map[string]interface{}{"e0": i})
+	}
+	if warnings := logger.Warnings(); len(warnings) != 0 {
+		t.Fatalf("warned %q at the threshold", warnings)
+	}
+
+	for i := 5; i < 10; i++ {
+		mustWrite(t, d, "events", map[string]interface{}{fmt.Sprint("e", i): i})
+	}
+	warnings := logger.Warnings()
+	if len(warnings) != 1 || warnings[0] != "Collection 'events' holds 6 files, consider sharding it with PathFor" {
+		t.Fatalf("warned %q, want one warning on crossing the threshold", warnings)
+	}
+}
+
+func TestReadAllOrder(t *testing.T) {
+	names := []string{"b", "a10", "Z", "a", "a2", "B"}
+	want := []string{"B", "Z", "a", "a10", "a2", "b"}
+
+	for name, layout := range layouts {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			d := openTestDriver(t, dir, WithLayout(layout))
+			// Under PerFile, some records are compressed and some not.
+			compressed := d
+			if layout == PerFile {
+				compressed = d.With(WithCompress(true))
+			}
+			for i, resource := range names {
+				writer := d
+				if i%2 == 1 {
+					writer = compressed
+				}
+				if err := writer.Write("items", resource, map[string]string{"Name": resource}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for i := 0; i < 3; i++ {
+				records, err := d.ReadAll("items")
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got []string
+				for _, item := range decodeAll[map[string]string](t, records) {
+					got = append(got, item["Name"])
+				}
+				if fmt.Sprint(got) != fmt.Sprint(want) {
+					t.Fatalf("ReadAll returned %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
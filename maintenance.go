@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// maintenanceJitter is the largest fraction of the interval added to each
+// wait, so drivers started together do not all run at once.
+const maintenanceJitter = 0.1
+
+// StartMaintenance runs Compact across every collection in the background,
+// about once per interval, and returns the function that stops it. stop
+// waits for a run in progress to finish and may be called more than once.
+// Nothing runs for a read-only driver or a non-positive interval.
+func (d *Driver) StartMaintenance(interval time.Duration) (stop func()) {
+	if d.opts.ReadOnly || interval <= 0 {
+		return func() {}
+	}
+
+	var (
+		done = make(chan struct{})
+		wg   sync.WaitGroup
+		once sync.Once
+	)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for {
+			wait := interval + time.Duration(rand.Float64()*maintenanceJitter*float64(interval))
+			timer := time.NewTimer(wait)
+
+			select {
+			case <-timer.C:
+				d.maintain()
+			case <-done:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+
+	return func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+		})
+	}
+}
+
+func (d *Driver) maintain() {
+	leave, err := d.enter()
+	if err != nil {
+		return
+	}
+	defer leave()
+
+	collections, err := d.collections()
+	if err != nil {
+		d.log.Error("Maintenance: unable to list collections: %s\n", err)
+		return
+	}
+
+	for _, collection := range collections {
+		report, err := d.Compact(collection)
+		if errors.Is(err, ErrClosed) {
+			return
+		}
+		if err != nil {
+			d.log.Error("Maintenance: unable to compact '%s': %s\n", collection, err)
+			continue
+		}
+		if report.TempFilesRemoved > 0 {
+			d.log.Debug("Maintenance: removed %d temp files from '%s'\n", report.TempFilesRemoved, collection)
+		}
+	}
+}
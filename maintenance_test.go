@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitGone polls until path no longer exists, reporting whether it went
+// within timeout.
+func waitGone(path string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return true
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return false
+}
+
+func TestMaintenanceRunsAndStops(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+	writeDemoUsers(t, d)
+
+	stray := filepath.Join(dir, "users", "Zed.json.tmp")
+	if err := os.WriteFile(stray, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := d.StartMaintenance(5 * time.Millisecond)
+	if !waitGone(stray, 5*time.Second) {
+		stop()
+		t.Fatal("maintenance never removed the stray temp file")
+	}
+	stop()
+	stop()
+
+	if err := os.WriteFile(stray, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if waitGone(stray, 50*time.Millisecond) {
+		t.Fatal("maintenance still ran after stop")
+	}
+}
+
+func TestMaintenanceReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	writeDemoUsers(t, openTestDriver(t, dir))
+	stray := filepath.Join(dir, "users", "Zed.json.tmp")
+	if err := os.WriteFile(stray, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := openTestDriver(t, dir, WithReadOnly(true)).StartMaintenance(time.Millisecond)
+	defer stop()
+
+	if waitGone(stray, 50*time.Millisecond) {
+		t.Fatal("maintenance ran on a read-only driver")
+	}
+}
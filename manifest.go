@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// ManifestEntry describes a single record in a manifest. Size and Hash are
+// those of the record's JSON as Read sees it, the same bytes Hash covers.
+type ManifestEntry struct {
+	Collection string `json:"collection"`
+	Resource   string `json:"resource"`
+	Size       int    `json:"size"`
+	Hash       string `json:"hash"`
+}
+
+// Discrepancy is a difference VerifyManifest found between a manifest and
+// the database. Kind is "added" for a record the manifest lacks, "removed"
+// for one the database lacks, and "changed" for one whose content differs.
+type Discrepancy struct {
+	Collection string
+	Resource   string
+	Kind       string
+}
+
+// Manifest lists every record in the database with its size and SHA-256,
+// as JSON, ordered by collection and resource. Keep it alongside a backup
+// and check it later with VerifyManifest.
+func (d *Driver) Manifest() ([]byte, error) {
+	leave, err := d.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	entries, err := d.manifestEntries()
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(entries, "", "\t")
+}
+
+func (d *Driver) manifestEntries() ([]ManifestEntry, error) {
+	collections, err := d.collections()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []ManifestEntry{}
+	for _, collection := range collections {
+		resources, read, err := d.records(collection)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, resource := range resources {
+			b, err := read(resource)
+			if err != nil {
+				return nil, err
+			}
+
+			sum := sha256.Sum256(b)
+			entries = append(entries, ManifestEntry{
+				Collection: collection,
+				Resource:   resource,
+				Size:       len(b),
+				Hash:       hex.EncodeToString(sum[:]),
+			})
+		}
+	}
+	return entries, nil
+}
+
+// VerifyManifest compares a manifest from Manifest against the database as
+// it is now, and returns every record that was added, removed or changed
+// since, ordered by collection and resource.
+func (d *Driver) VerifyManifest(manifest []byte) ([]Discrepancy, error) {
+	leave, err := d.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	var want []ManifestEntry
+	if err := json.Unmarshal(manifest, &want); err != nil {
+		return nil, fmt.Errorf("unable to read manifest: %w", err)
+	}
+
+	have, err := d.manifestEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct{ collection, resource string }
+
+	wanted := make(map[key]ManifestEntry, len(want))
+	for _, entry := range want {
+		wanted[key{entry.Collection, entry.Resource}] = entry
+	}
+
+	var discrepancies []Discrepancy
+	for _, entry := range have {
+		k := key{entry.Collection, entry.Resource}
+		old, ok := wanted[k]
+		delete(wanted, k)
+
+		switch {
+		case !ok:
+			discrepancies = append(discrepancies, Discrepancy{entry.Collection, entry.Resource, "added"})
+		case old.Size != entry.Size || old.Hash != entry.Hash:
+			discrepancies = append(discrepancies, Discrepancy{entry.Collection, entry.Resource, "changed"})
+		}
+	}
+
+	// What is left of the manifest is gone from the database.
+	for k := range wanted {
+		discrepancies = append(discrepancies, Discrepancy{k.collection, k.resource, "removed"})
+	}
+
+	sort.Slice(discrepancies, func(i, j int) bool {
+		if discrepancies[i].Collection != discrepancies[j].Collection {
+			return discrepancies[i].Collection < discrepancies[j].Collection
+		}
+		return discrepancies[i].Resource < discrepancies[j].Resource
+	})
+	return discrepancies, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestVerifyManifest(t *testing.T) {
+	d := newTestDriver(t)
+	writeDemoUsers(t, d)
+	writeEvents(t, d, 3)
+
+	manifest, err := d.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []ManifestEntry
+	if err := json.Unmarshal(manifest, &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(demoUsers())+3 || entries[0].Collection != "events" || entries[0].Hash == "" {
+		t.Fatalf("Manifest listed %+v", entries)
+	}
+
+	if discrepancies, err := d.VerifyManifest(manifest); err != nil || len(discrepancies) != 0 {
+		t.Fatalf("VerifyManifest of an unchanged database returned %v, %v", discrepancies, err)
+	}
+
+	if err := d.SetField("users", "Arnab", "Company", "Acme"); err != nil {
+		t.Fatal(err)
+	}
+	discrepancies, err := d.VerifyManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[{users Arnab changed}]"; fmt.Sprint(discrepancies) != want {
+		t.Fatalf("VerifyManifest after one change returned %v, want %v", discrepancies, want)
+	}
+
+	if err := d.Delete("events", "e0001"); err != nil {
+		t.Fatal(err)
+	}
+	mustWrite(t, d, "users", map[string]interface{}{"Zoe": User{Name: "Zoe"}})
+	discrepancies, err = d.VerifyManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[{events e0001 removed} {users Arnab changed} {users Zoe added}]"; fmt.Sprint(discrepancies) != want {
+		t.Fatalf("VerifyManifest returned %v, want %v", discrepancies, want)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Match returns the resources in collection whose names match pattern, using
+// filepath.Match syntax, e.g. "user_*". The pattern is matched against the
+// resource name without its extension.
+func (d *Driver) Match(collection, pattern string) ([]string, error) {
+	leave, err := d.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	if collection == "" {
+		return nil, fmt.Errorf("missing collection - unable to read")
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+
+	resources, err := d.Keys(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []string{}
+	for _, resource := range resources {
+		if ok, _ := filepath.Match(pattern, resource); ok {
+			matches = append(matches, resource)
+		}
+	}
+	return matches, nil
+}
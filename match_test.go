@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	d := newTestDriver(t)
+	mustWrite(t, d, "keys", map[string]interface{}{
+		"user_1": 1, "user_2": 2, "user_10": 10, "admin_1": 1, "user": 0,
+	})
+
+	for pattern, want := range map[string]string{
+		"user_*":  "[user_1 user_10 user_2]",
+		"user_?":  "[user_1 user_2]",
+		"*_1":     "[admin_1 user_1]",
+		"user":    "[user]",
+		"guest_*": "[]",
+	} {
+		got, err := d.Match("keys", pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(got) != want {
+			t.Errorf("Match(%q) = %v, want %v", pattern, got, want)
+		}
+	}
+
+	if _, err := d.Match("keys", "[user"); err == nil {
+		t.Fatal("Match accepted a malformed pattern")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// metaSuffix marks the sidecar holding a record's metadata. Resource names
+// ending in it are reserved.
+const metaSuffix = ".meta"
+
+// metaFile returns the file a record's metadata is stored in.
+func (d *Driver) metaFile(collection, resource string) string {
+	return d.recordBase(collection, resource) + metaSuffix + jsonExt
+}
+
+// checkReserved rejects resource names that would collide with a sidecar.
+func checkReserved(resource string) error {
+	if strings.HasSuffix(resource, metaSuffix) {
+		return fmt.Errorf("%w: %v ends in %v, which is reserved for metadata", ErrInvalidName, resource, metaSuffix)
+	}
+	return nil
+}
+
+// SetMeta attaches meta to an existing record, replacing any metadata it
+// had. It is kept outside the record body and removed along with the
+// record. An empty meta removes the metadata.
+func (d *Driver) SetMeta(collection, resource string, meta map[string]string) error {
+	leave, err := d.enter()
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if collection == "" {
+		return fmt.Errorf("missing collection - no place to save metadata")
+	}
+	if resource == "" {
+		return fmt.Errorf("missing resource - unable to save metadata (no name)")
+	}
+
+	mutex := d.getOrCreateMutex(collection)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if _, err := d.readRecord(collection, resource); err != nil {
+		return err
+	}
+
+	path := d.metaFile(collection, resource)
+	if len(meta) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	b, err := json.MarshalIndent(meta, "", "\t")
+	if err != nil {
+		return err
+	}
+	if !d.opts.NoTrailingNewline {
+		b = append(b, byte('\n'))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return diskError(err)
+	}
+	return d.writeFile(path, b)
+}
+
+// GetMeta returns the metadata attached to a record, which is empty if none
+// was set, or ErrNotFound if the record does not exist.
+func (d *Driver) GetMeta(collection, resource string) (map[string]string, error) {
+	leave, err := d.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	if collection == "" {
+		return nil, fmt.Errorf("missing collection - unable to read")
+	}
+	if resource == "" {
+		return nil, fmt.Errorf("missing resource - unable to read metadata (no name)")
+	}
+
+	mutex := d.getOrCreateMutex(collection)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if _, err := d.readRecord(collection, resource); err != nil {
+		return nil, err
+	}
+
+	meta := make(map[string]string)
+
+	b, err := d.readFile(d.metaFile(collection, resource))
+	if os.IsNotExist(err) {
+		return meta, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return nil, fmt.Errorf("unable to read metadata of %v/%v: %w", collection, resource, err)
+	}
+	return meta, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMeta(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+	writeDemoUsers(t, d)
+
+	if err := d.SetMeta("users", "Arnab", map[string]string{"owner": "ops", "tag": "vip"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetMeta("users", "Nobody", map[string]string{"owner": "ops"}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("SetMeta on a missing record returned %v, want ErrNotFound", err)
+	}
+
+	// The sidecar is not a record.
+	if keys, err := d.Keys("users"); err != nil || len(keys) != len(demoUsers()) {
+		t.Fatalf("Keys = %v, %v", keys, err)
+	}
+	if records, err := d.ReadAll("users"); err != nil || len(records) != len(demoUsers()) {
+		t.Fatalf("ReadAll returned %d records, %v", len(records), err)
+	}
+
+	if err := d.RenameCollection("users", "people"); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := d.GetMeta("people", "Arnab")
+	if err != nil || meta["owner"] != "ops" || meta["tag"] != "vip" {
+		t.Fatalf("GetMeta after renaming = %v, %v", meta, err)
+	}
+	if meta, err := d.GetMeta("people", "John"); err != nil || len(meta) != 0 {
+		t.Fatalf("GetMeta without metadata = %v, %v", meta, err)
+	}
+
+	if err := d.Delete("people", "Arnab"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "people", "Arnab.meta.json")); !os.IsNotExist(err) {
+		t.Fatalf("Delete left the metadata: %v", err)
+	}
+	if _, err := d.GetMeta("people", "Arnab"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetMeta after Delete returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestMetaReservedName(t *testing.T) {
+	d := newTestDriver(t)
+	if err := d.Write("users", "Arnab.meta", demoUsers()[0]); !errors.Is(err, ErrInvalidName) {
+		t.Fatalf("Write of a reserved name returned %v, want ErrInvalidName", err)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Migrate rewrites every record in collection with the output of transform,
+// holding the collection lock for the whole run. Records for which transform
+// returns ErrUnchanged are left untouched. The output goes through the
+// checks Write makes, and is stored with the same trailing newline.
+func (d *Driver) Migrate(collection string, transform func(raw []byte) ([]byte, error)) error {
+	leave, err := d.enter()
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if collection == "" {
+		return fmt.Errorf("missing collection - unable to migrate")
+	}
+	if transform == nil {
+		return fmt.Errorf("missing transform - unable to migrate")
+	}
+
+	mutex := d.getOrCreateMutex(collection)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	resources, read, err := d.records(collection)
+	if err != nil {
+		return err
+	}
+
+	for _, resource := range resources {
+		b, err := read(resource)
+		if err != nil {
+			return err
+		}
+
+		out, err := transform(b)
+		if errors.Is(err, ErrUnchanged) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("unable to migrate %v/%v: %w", collection, resource, err)
+		}
+		if !json.Valid(out) {
+			return fmt.Errorf("unable to migrate %v/%v: transform produced invalid JSON", collection, resource)
+		}
+		if err := d.checkEncoding(collection, resource, out); err != nil {
+			return err
+		}
+		if err := d.checkRecord(collection, resource, out); err != nil {
+			return err
+		}
+
+		if d.opts.DryRun {
+			d.log.Info("Would migrate '%s/%s'\n", collection, resource)
+			continue
+		}
+
+		if out, err = d.finishRecord(collection, resource, out); err != nil {
+			return err
+		}
+		if _, err := d.writeRecord(collection, resource, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
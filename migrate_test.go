@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// addCountry fills in a default country for users that have none.
+func addCountry(raw []byte) ([]byte, error) {
+	var user map[string]interface{}
+	if err := json.Unmarshal(raw, &user); err != nil {
+		return nil, err
+	}
+	if _, ok := user["Country"]; ok {
+		return nil, ErrUnchanged
+	}
+	user["Country"] = "India"
+	return json.Marshal(user)
+}
+
+func TestMigrateAddsDefaultField(t *testing.T) {
+	d := newTestDriver(t)
+	mustWrite(t, d, "users", map[string]interface{}{
+		"Arnab": map[string]string{"Name": "Arnab"},
+		"John":  map[string]string{"Name": "John"},
+		"Jane":  map[string]string{"Name": "Jane", "Country": "UK"},
+	})
+
+	if err := d.Migrate("users", addCountry); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"Arnab": "India", "John": "India", "Jane": "UK"}
+	for resource, country := range want {
+		var user map[string]string
+		if err := d.Read("users", resource, &user); err != nil {
+			t.Fatal(err)
+		}
+		if user["Name"] != resource || user["Country"] != country {
+			t.Errorf("%v migrated to %v, want Country %v", resource, user, country)
+		}
+	}
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	d := newTestDriver(t)
+	mustWrite(t, d, "users", map[string]interface{}{"Arnab": map[string]string{"Name": "Arnab"}})
+
+	if err := d.With(WithDryRun(true)).Migrate("users", addCountry); err != nil {
+		t.Fatal(err)
+	}
+
+	var user map[string]string
+	if err := d.Read("users", "Arnab", &user); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := user["Country"]; ok {
+		t.Fatalf("dry run migrated Arnab to %v", user)
+	}
+}
+
+func TestMigrateInvalidJSON(t *testing.T) {
+	d := newTestDriver(t)
+	mustWrite(t, d, "users", map[string]interface{}{"Arnab": map[string]string{"Name": "Arnab"}})
+
+	err := d.Migrate("users", func([]byte) ([]byte, error) { return []byte("{"), nil })
+	if err == nil {
+		t.Fatal("Migrate accepted a transform producing invalid JSON")
+	}
+
+	var user map[string]string
+	if err := d.Read("users", "Arnab", &user); err != nil {
+		t.Fatalf("record unreadable after a failed migration: %v", err)
+	}
+}
+
+func TestMigrateChecksOutput(t *testing.T) {
+	dir := t.TempDir()
+	rejectNoCountry := func(_, _ string, raw []byte) error {
+		if !strings.Contains(string(raw), "Country") {
+			return errors.New("missing Country")
+		}
+		return nil
+	}
+	d := openTestDriver(t, dir, WithRejectEmpty(true), WithValidator(rejectNoCountry))
+	mustWrite(t, d, "users", map[string]interface{}{"Arnab": map[string]string{"Name": "Arnab", "Country": "India"}})
+
+	var verr *ValidationError
+	err := d.Migrate("users", func([]byte) ([]byte, error) { return []byte(`{"Name": "Arnab"}`), nil })
+	if !errors.As(err, &verr) {
+		t.Fatalf("Migrate past the validator returned %v, want a *ValidationError", err)
+	}
+	if err := d.Migrate("users", func([]byte) ([]byte, error) { return []byte(" { } "), nil }); !errors.Is(err, ErrEmptyRecord) {
+		t.Fatalf("Migrate to an empty record returned %v, want ErrEmptyRecord", err)
+	}
+
+	if err := d.Migrate("users", func([]byte) ([]byte, error) { return []byte(`{"Country": "UK"}`), nil }); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "users", "Arnab.json"))
+	if err != nil || string(b) != "{\"Country\": \"UK\"}\n" {
+		t.Fatalf("migrated record stored %q, %v", b, err)
+	}
+}
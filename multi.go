@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// WriteItem is a single record for WriteMulti.
+type WriteItem struct {
+	Collection string
+	Resource   string
+	Value      interface{}
+}
+
+// WriteMulti writes records across any number of collections while holding
+// all of their locks. Every record is written to a temp file first, and only
+// once all of them are staged are they renamed into place, so a failure
+// while staging leaves every collection untouched. A failed rename still
+// leaves the records renamed before it written; the window for that is only
+// as wide as the renames themselves. Of several items naming the same
+// record, the last wins. Options.BufferWrites does not apply.
+func (d *Driver) WriteMulti(items []WriteItem) error {
+	leave, err := d.enter()
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+
+	group := make(map[string]map[string][]byte)
+	for _, item := range items {
+		if item.Collection == "" {
+			return fmt.Errorf("missing collection - no place to save record")
+		}
+		if item.Resource == "" {
+			return fmt.Errorf("missing resource - unable to save record (no name)")
+		}
+		if err := d.checkName(item.Collection, item.Resource); err != nil {
+			return err
+		}
+
+		b, err := d.marshal(item.Collection, item.Resource, item.Value)
+		if err != nil {
+			return err
+		}
+
+		if group[item.Collection] == nil {
+			group[item.Collection] = make(map[string][]byte)
+		}
+		group[item.Collection][item.Resource] = b
+	}
+
+	for _, item := range items {
+		if err := d.throttle(context.Background(), item.Collection); err != nil {
+			return err
+		}
+	}
+
+	collections := sortedResources(group)
+
+	return d.withTimeout(func() error {
+		unlock := d.lockCollections(collections...)
+		defer unlock()
+
+		for _, collection := range collections {
+			d.warnIfUnknown(collection)
+		}
+		return d.writeMulti(collections, group)
+	})
+}
+
+// writeMulti stages and then renames the records in group. The caller must
+// hold the lock of every collection.
+func (d *Driver) writeMulti(collections []string, group map[string]map[string][]byte) error {
+	type staged struct {
+		collection, path, tmpPath string
+		resources                 []string
+	}
+
+	var temps []staged
+	discard := func() {
+		for _, temp := range temps {
+			os.Remove(temp.tmpPath)
+		}
+	}
+
+	stage := func(collection, path string, b []byte, resources ...string) error {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return diskError(err)
+		}
+		path, tmpPath, err := d.stageFile(path, b)
+		if err != nil {
+			return err
+		}
+		temps = append(temps, staged{collection, path, tmpPath, resources})
+		return nil
+	}
+
+	for _, collection := range collections {
+		encoded := group[collection]
+
+		if d.opts.Layout == SingleFile {
+			records, err := d.readCollectionFile(collection)
+			if err != nil && !os.IsNotExist(err) {
+				discard()
+				return err
+			}
+			if records == nil {
+				records = make(map[string]json.RawMessage)
+			}
+			for resource, b := range encoded {
+				records[resource] = json.RawMessage(b)
+			}
+
+			b, err := d.encodeCollectionFile(records)
+			if err == nil {
+				err = stage(collection, d.collectionFile(collection), b, sortedResources(encoded)...)
+			}
+			if err != nil {
+				discard()
+				return err
+			}
+			continue
+		}
+
+		for _, resource := range sortedResources(encoded) {
+			if err := stage(collection, d.recordFile(collection, resource), encoded[resource], resource); err != nil {
+				discard()
+				return err
+			}
+		}
+	}
+
+	for i, temp := range temps {
+		if err := replaceFile(temp.tmpPath, temp.path); err != nil {
+			temps = temps[i+1:]
+			discard()
+			return err
+		}
+		d.syncer.markDirty(temp.path)
+
+		for _, resource := range temp.resources {
+			d.buffer.discard(temp.collection, resource)
+			d.forget(temp.collection, resource)
+			if d.opts.Layout != SingleFile {
+				if err := d.removeStale(temp.collection, resource); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, collection := range collections {
+		if err := d.logChange(collection, false, sortedResources(group[collection])...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
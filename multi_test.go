@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteMulti(t *testing.T) {
+	for name, layout := range layouts {
+		t.Run(name, func(t *testing.T) {
+			d := newTestDriver(t, WithLayout(layout))
+
+			err := d.WriteMulti([]WriteItem{
+				{"accounts", "alice", map[string]int{"Balance": 90}},
+				{"accounts", "bob", map[string]int{"Balance": 110}},
+				{"transfers", "t1", map[string]interface{}{"From": "alice", "To": "bob", "Amount": 10}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var account map[string]int
+			if err := d.Read("accounts", "bob", &account); err != nil || account["Balance"] != 110 {
+				t.Fatalf("Read(accounts/bob) returned %v, %v", account, err)
+			}
+			var transfer map[string]interface{}
+			if err := d.Read("transfers", "t1", &transfer); err != nil || transfer["To"] != "bob" {
+				t.Fatalf("Read(transfers/t1) returned %v, %v", transfer, err)
+			}
+		})
+	}
+}
+
+func TestWriteMultiStagingFailure(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+	mustWrite(t, d, "accounts", map[string]interface{}{"alice": map[string]int{"Balance": 100}})
+
+	// A file where the transfers collection belongs fails its staging,
+	// after the accounts records are already staged.
+	if err := os.WriteFile(filepath.Join(dir, "transfers"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := d.WriteMulti([]WriteItem{
+		{"accounts", "alice", map[string]int{"Balance": 90}},
+		{"accounts", "bob", map[string]int{"Balance": 10}},
+		{"transfers", "t1", map[string]interface{}{"From": "alice", "To": "bob", "Amount": 10}},
+	})
+	if err == nil {
+		t.Fatal("WriteMulti succeeded with a collection it cannot write")
+	}
+
+	var account map[string]int
+	if err := d.Read("accounts", "alice", &account); err != nil || account["Balance"] != 100 {
+		t.Fatalf("failed WriteMulti left accounts/alice as %v, %v", account, err)
+	}
+	if err := d.Read("accounts", "bob", &account); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("failed WriteMulti wrote accounts/bob: %v", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "accounts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			t.Fatalf("failed WriteMulti left %v behind", entry.Name())
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// decodeObject decodes a record for the operations that only make sense on
+// JSON objects. Arrays, strings, numbers and null are rejected with
+// ErrNotObject so they are never rewritten as something else.
+func (d *Driver) decodeObject(b []byte) (map[string]interface{}, error) {
+	var v interface{}
+	if err := d.decode(b, &v); err != nil {
+		return nil, err
+	}
+
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, ErrNotObject
+	}
+	return obj, nil
+}
+
+// SetField sets a single field of a record that is a JSON object, creating
+// the objects along path as needed, and writes the result under the
+// collection lock. path names nested fields with dots, as in "Address.City";
+// every field on the way must be an object if it exists, or ErrNotObject is
+// returned. The other fields are left as they are.
+func (d *Driver) SetField(collection, resource, path string, value interface{}) error {
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if collection == "" {
+		return fmt.Errorf("missing collection - unable to update")
+	}
+	if resource == "" {
+		return fmt.Errorf("missing resource - unable to update record (no name)")
+	}
+
+	fields := strings.Split(path, ".")
+	for _, field := range fields {
+		if field == "" {
+			return fmt.Errorf("invalid field path %q", path)
+		}
+	}
+
+	return d.withTimeout(func() error {
+		mutex := d.getOrCreateMutex(collection)
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		b, err := d.readRecord(collection, resource)
+		if err != nil {
+			return err
+		}
+
+		// Numbers are kept as json.Number so the rewrite does not lose
+		// precision in the fields left alone.
+		doc, err := d.With(WithUseNumber(true)).decodeObject(b)
+		if errors.Is(err, ErrNotObject) {
+			return fmt.Errorf("%w: %v/%v", ErrNotObject, collection, resource)
+		}
+		if err != nil {
+			return decodeError(collection, resource, b, err)
+		}
+
+		node := doc
+		for i, field := range fields[:len(fields)-1] {
+			child, ok := node[field]
+			if !ok {
+				child = make(map[string]interface{})
+				node[field] = child
+			}
+			next, ok := child.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("%w: %v in %v/%v", ErrNotObject, strings.Join(fields[:i+1], "."), collection, resource)
+			}
+			node = next
+		}
+		node[fields[len(fields)-1]] = value
+
+		out, err := d.marshal(collection, resource, doc)
+		if err != nil {
+			return err
+		}
+		_, err = d.writeRecord(collection, resource, out)
+		return err
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestArrayRecordRoundTrip(t *testing.T) {
+	d := newTestDriver(t)
+	if err := d.Write("lists", "primes", []int{2, 3, 5, 7}); err != nil {
+		t.Fatal(err)
+	}
+
+	var primes []int
+	if err := d.Read("lists", "primes", &primes); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(primes) != "[2 3 5 7]" {
+		t.Fatalf("read back %v, want [2 3 5 7]", primes)
+	}
+
+	records, err := d.ReadAll("lists")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all := decodeAll[[]int](t, records); len(all) != 1 || fmt.Sprint(all[0]) != "[2 3 5 7]" {
+		t.Fatalf("ReadAll returned %q", records)
+	}
+}
+
+func TestDecodeObjectRejectsOtherValues(t *testing.T) {
+	d := newTestDriver(t)
+	for _, doc := range []string{`[2, 3, 5, 7]`, `"primes"`, `7`, `null`} {
+		if _, err := d.decodeObject([]byte(doc)); !errors.Is(err, ErrNotObject) {
+			t.Errorf("decodeObject(%s) returned %v, want ErrNotObject", doc, err)
+		}
+	}
+
+	obj, err := d.decodeObject([]byte(`{"Name": "Arnab"}`))
+	if err != nil || obj["Name"] != "Arnab" {
+		t.Fatalf("decodeObject of an object returned %v, %v", obj, err)
+	}
+}
+
+func TestUseNumberKeepsLargeIntegers(t *testing.T) {
+	d := newTestDriver(t, WithUseNumber(true))
+	const id = "1234567890123456789"
+	if err := d.Write("users", "Arnab", map[string]interface{}{"ID": json.Number(id), "Name": "Arnab"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.SetField("users", "Arnab", "Name", "Arnab B"); err != nil {
+		t.Fatal(err)
+	}
+
+	var user map[string]interface{}
+	if err := d.Read("users", "Arnab", &user); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := user["ID"].(json.Number); !ok || got.String() != id {
+		t.Fatalf("ID read back as %#v, want json.Number(%v)", user["ID"], id)
+	}
+
+	// Rewriting what was read keeps the digits too.
+	if err := d.Write("users", "Arnab", user); err != nil {
+		t.Fatal(err)
+	}
+	var again struct{ ID int64 }
+	if err := d.Read("users", "Arnab", &again); err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != 1234567890123456789 {
+		t.Fatalf("ID = %d after a read-modify-write, want %v", again.ID, id)
+	}
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jcelliott/lumber"
+)
+
+type (
+	// Option adjusts the Options a Driver operates with.
+	Option interface {
+		apply(*Options)
+	}
+
+	optionFunc func(*Options)
+)
+
+func (f optionFunc) apply(o *Options) {
+	f(o)
+}
+
+func (o *Options) apply(dst *Options) {
+	if o != nil {
+		*dst = *o
+	}
+}
+
+// normalize fills in defaults and rejects option combinations that cannot
+// work, so a misconfigured driver fails in New rather than on first use.
+func (o *Options) normalize(dir string) error {
+	if o.Logger == nil {
+		o.Logger = lumber.NewConsoleLogger((lumber.INFO))
+	}
+	if o.Locks == nil {
+		o.Locks = NewLocks()
+	}
+	if o.TempSuffix == "" {
+		o.TempSuffix = ".tmp"
+	}
+	if o.Compressor == nil {
+		o.Compressor = gzipCompressor{}
+	}
+	if o.MaxNameLength == 0 {
+		o.MaxNameLength = 255
+	}
+	if o.IDGenerator == nil {
+		o.IDGenerator = newUUID
+	}
+
+	switch o.Layout {
+	case PerFile, SingleFile:
+	default:
+		return fmt.Errorf("invalid options - unknown layout %d", o.Layout)
+	}
+
+	if o.PathFor != nil && o.Layout == SingleFile {
+		return fmt.Errorf("invalid options - PathFor only applies to the PerFile layout")
+	}
+	if o.Compress && o.Layout == SingleFile {
+		return fmt.Errorf("invalid options - Compress only applies to the PerFile layout")
+	}
+	if ext := o.Compressor.Ext(); !strings.HasPrefix(ext, ".") || len(ext) < 2 || strings.ContainsAny(ext, `/\`) {
+		return fmt.Errorf("invalid options - Compressor extension %q must be a dot and a name", ext)
+	}
+	if strings.ContainsAny(o.TempSuffix, `/\`) || reservedSuffix(o.TempSuffix) || strings.HasSuffix(o.TempSuffix, o.Compressor.Ext()) {
+		return fmt.Errorf("invalid options - TempSuffix %q cannot be told apart from stored files", o.TempSuffix)
+	}
+	if o.FallbackDir != "" {
+		o.FallbackDir = filepath.Clean(o.FallbackDir)
+		if o.FallbackDir == dir {
+			return fmt.Errorf("invalid options - FallbackDir is the database directory %v", dir)
+		}
+	}
+	o.ReadDirs = append([]string(nil), o.ReadDirs...)
+	for i, readDir := range o.ReadDirs {
+		if o.ReadDirs[i] = filepath.Clean(readDir); o.ReadDirs[i] == dir {
+			return fmt.Errorf("invalid options - read dir %v is the database directory", dir)
+		}
+	}
+	if o.MaxOpenFiles < 0 {
+		return fmt.Errorf("invalid options - negative MaxOpenFiles %d", o.MaxOpenFiles)
+	}
+	if o.BufferMaxRecords < 0 {
+		return fmt.Errorf("invalid options - negative BufferMaxRecords %d", o.BufferMaxRecords)
+	}
+	if o.BufferMaxDelay < 0 {
+		return fmt.Errorf("invalid options - negative BufferMaxDelay %v", o.BufferMaxDelay)
+	}
+	if o.CacheSize < 0 {
+		return fmt.Errorf("invalid options - negative CacheSize %d", o.CacheSize)
+	}
+	if o.DirSizeWarnThreshold < 0 {
+		return fmt.Errorf("invalid options - negative DirSizeWarnThreshold %d", o.DirSizeWarnThreshold)
+	}
+	if o.SyncInterval < 0 {
+		return fmt.Errorf("invalid options - negative SyncInterval %v", o.SyncInterval)
+	}
+	if len(o.EncryptFields) > 0 {
+		switch len(o.EncryptionKey) {
+		case 16, 24, 32:
+		default:
+			return fmt.Errorf("invalid options - EncryptionKey of %d bytes, want 16, 24 or 32", len(o.EncryptionKey))
+		}
+	}
+	if o.MaxNameLength < 0 {
+		return fmt.Errorf("invalid options - negative MaxNameLength %d", o.MaxNameLength)
+	}
+	if o.OperationTimeout < 0 {
+		return fmt.Errorf("invalid options - negative OperationTimeout %v", o.OperationTimeout)
+	}
+	if o.ReadOnly {
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			return fmt.Errorf("invalid options - read-only database %v does not exist", dir)
+		}
+	}
+	return nil
+}
+
+// reservedSuffix reports whether a temp file ending in suffix could be
+// mistaken for a record, a compressed record or a blob.
+func reservedSuffix(suffix string) bool {
+	for _, ext := range []string{jsonExt, ".gz", ".blob"} {
+		if strings.HasSuffix(suffix, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithLogger sets the Logger the driver reports through.
+func WithLogger(logger Logger) Option {
+	return optionFunc(func(o *Options) {
+		o.Logger = logger
+	})
+}
+
+// WithReadOnly sets Options.ReadOnly.
+func WithReadOnly(readOnly bool) Option {
+	return optionFunc(func(o *Options) {
+		o.ReadOnly = readOnly
+	})
+}
+
+// WithDryRun sets Options.DryRun.
+func WithDryRun(dryRun bool) Option {
+	return optionFunc(func(o *Options) {
+		o.DryRun = dryRun
+	})
+}
+
+// WithTrackOverwrites sets Options.TrackOverwrites.
+func WithTrackOverwrites(track bool) Option {
+	return optionFunc(func(o *Options) {
+		o.TrackOverwrites = track
+	})
+}
+
+// WithTrailingNewline controls whether records end with '\n'; it is the
+// inverse of Options.NoTrailingNewline.
+func WithTrailingNewline(enabled bool) Option {
+	return optionFunc(func(o *Options) {
+		o.NoTrailingNewline = !enabled
+	})
+}
+
+// WithUseNumber sets Options.UseNumber.
+func WithUseNumber(useNumber bool) Option {
+	return optionFunc(func(o *Options) {
+		o.UseNumber = useNumber
+	})
+}
+
+// WithOperationTimeout sets Options.OperationTimeout.
+func WithOperationTimeout(timeout time.Duration) Option {
+	return optionFunc(func(o *Options) {
+		o.OperationTimeout = timeout
+	})
+}
+
+// WithFollowSymlinks sets Options.FollowSymlinks.
+func WithFollowSymlinks(follow bool) Option {
+	return optionFunc(func(o *Options) {
+		o.FollowSymlinks = follow
+	})
+}
+
+// WithLayout sets Options.Layout.
+func WithLayout(layout Layout) Option {
+	return optionFunc(func(o *Options) {
+		o.Layout = layout
+	})
+}
+
+// WithWarnUnknownCollection sets Options.WarnUnknownCollection.
+func WithWarnUnknownCollection(warn bool) Option {
+	return optionFunc(func(o *Options) {
+		o.WarnUnknownCollection = warn
+	})
+}
+
+// WithLockFreeReads sets Options.LockFreeReads.
+func WithLockFreeReads(lockFree bool) Option {
+	return optionFunc(func(o *Options) {
+		o.LockFreeReads = lockFree
+	})
+}
+
+// WithPathFor sets Options.PathFor.
+func WithPathFor(pathFor func(collection, resource string) string) Option {
+	return optionFunc(func(o *Options) {
+		o.PathFor = pathFor
+	})
+}
+
+// WithDisallowUnknownFields sets Options.DisallowUnknownFields.
+func WithDisallowUnknownFields(disallow bool) Option {
+	return optionFunc(func(o *Options) {
+		o.DisallowUnknownFields = disallow
+	})
+}
+
+// WithCompress sets Options.Compress.
+func WithCompress(compress bool) Option {
+	return optionFunc(func(o *Options) {
+		o.Compress = compress
+	})
+}
+
+// WithLocks sets Options.Locks.
+func WithLocks(locks *Locks) Option {
+	return optionFunc(func(o *Options) {
+		o.Locks = locks
+	})
+}
+
+// WithMaxOpenFiles sets Options.MaxOpenFiles.
+func WithMaxOpenFiles(max int) Option {
+	return optionFunc(func(o *Options) {
+		o.MaxOpenFiles = max
+	})
+}
+
+// WithTrackChanges sets Options.TrackChanges.
+func WithTrackChanges(track bool) Option {
+	return optionFunc(func(o *Options) {
+		o.TrackChanges = track
+	})
+}
+
+// WithTempSuffix sets Options.TempSuffix.
+func WithTempSuffix(suffix string) Option {
+	return optionFunc(func(o *Options) {
+		o.TempSuffix = suffix
+	})
+}
+
+// WithRejectEmpty sets Options.RejectEmpty.
+func WithRejectEmpty(reject bool) Option {
+	return optionFunc(func(o *Options) {
+		o.RejectEmpty = reject
+	})
+}
+
+// WithFallbackDir sets Options.FallbackDir.
+func WithFallbackDir(dir string) Option {
+	return optionFunc(func(o *Options) {
+		o.FallbackDir = dir
+	})
+}
+
+// WithValidateUTF8 sets Options.ValidateUTF8.
+func WithValidateUTF8(validate bool) Option {
+	return optionFunc(func(o *Options) {
+		o.ValidateUTF8 = validate
+	})
+}
+
+// WithEncodeKeys sets Options.EncodeKeys.
+func WithEncodeKeys(encode bool) Option {
+	return optionFunc(func(o *Options) {
+		o.EncodeKeys = encode
+	})
+}
+
+// WithReadDirs sets Options.ReadDirs.
+func WithReadDirs(dirs ...string) Option {
+	return optionFunc(func(o *Options) {
+		o.ReadDirs = dirs
+	})
+}
+
+// WithValidator sets Options.Validator.
+func WithValidator(validator func(collection, resource string, raw []byte) error) Option {
+	return optionFunc(func(o *Options) {
+		o.Validator = validator
+	})
+}
+
+// WithBufferWrites sets Options.BufferWrites, flushing after maxRecords
+// staged records or maxDelay, whichever comes first.
+func WithBufferWrites(maxRecords int, maxDelay time.Duration) Option {
+	return optionFunc(func(o *Options) {
+		o.BufferWrites = true
+		o.BufferMaxRecords = maxRecords
+		o.BufferMaxDelay = maxDelay
+	})
+}
+
+// WithCacheSize sets Options.CacheSize.
+func WithCacheSize(size int) Option {
+	return optionFunc(func(o *Options) {
+		o.CacheSize = size
+	})
+}
+
+// WithDirSizeWarnThreshold sets Options.DirSizeWarnThreshold.
+func WithDirSizeWarnThreshold(threshold int) Option {
+	return optionFunc(func(o *Options) {
+		o.DirSizeWarnThreshold = threshold
+	})
+}
+
+// WithSyncInterval sets Options.SyncInterval.
+func WithSyncInterval(interval time.Duration) Option {
+	return optionFunc(func(o *Options) {
+		o.SyncInterval = interval
+	})
+}
+
+// WithIDGenerator sets Options.IDGenerator.
+func WithIDGenerator(generate func() string) Option {
+	return optionFunc(func(o *Options) {
+		o.IDGenerator = generate
+	})
+}
+
+// WithCompressor sets Options.Compressor and turns on Options.Compress.
+func WithCompressor(c Compressor) Option {
+	return optionFunc(func(o *Options) {
+		o.Compress = true
+		o.Compressor = c
+	})
+}
+
+// WithRateLimiter sets Options.RateLimiter.
+func WithRateLimiter(limiter RateLimiter) Option {
+	return optionFunc(func(o *Options) {
+		o.RateLimiter = limiter
+	})
+}
+
+// WithCollectionRateLimit sets the limiter of collection in
+// Options.CollectionRateLimits.
+func WithCollectionRateLimit(collection string, limiter RateLimiter) Option {
+	return optionFunc(func(o *Options) {
+		limits := make(map[string]RateLimiter, len(o.CollectionRateLimits)+1)
+		for c, l := range o.CollectionRateLimits {
+			limits[c] = l
+		}
+		limits[collection] = limiter
+		o.CollectionRateLimits = limits
+	})
+}
+
+// WithMaxNameLength sets Options.MaxNameLength.
+func WithMaxNameLength(max int) Option {
+	return optionFunc(func(o *Options) {
+		o.MaxNameLength = max
+	})
+}
+
+// WithCoalesceReads sets Options.CoalesceReads.
+func WithCoalesceReads(coalesce bool) Option {
+	return optionFunc(func(o *Options) {
+		o.CoalesceReads = coalesce
+	})
+}
+
+// WithEncryptionKey sets Options.EncryptionKey.
+func WithEncryptionKey(key []byte) Option {
+	return optionFunc(func(o *Options) {
+		o.EncryptionKey = key
+	})
+}
+
+// WithEncryptFields sets the fields of collection in Options.EncryptFields.
+func WithEncryptFields(collection string, fields ...string) Option {
+	return optionFunc(func(o *Options) {
+		encrypted := make(map[string][]string, len(o.EncryptFields)+1)
+		for c, f := range o.EncryptFields {
+			encrypted[c] = f
+		}
+		encrypted[collection] = fields
+		o.EncryptFields = encrypted
+	})
+}
+
+// With returns a lightweight copy of d with opts applied on top of its
+// options. The copy shares d's directory and collection locks, so writes
+// through either are still serialized against each other.
+//
+// Options that only change how an operation behaves are safe to override
+// per copy: ReadOnly, DryRun, TrackOverwrites, NoTrailingNewline, UseNumber,
+// OperationTimeout, FollowSymlinks, WarnUnknownCollection, LockFreeReads,
+// DisallowUnknownFields, Compress, RejectEmpty, ValidateUTF8, IDGenerator and
+// the Logger.
+//
+// The resulting options are normalized as New does, so an *Options passed
+// whole gets the same defaults, keeping d's Logger if it sets none. Options
+// that New would reject are logged and ignored, leaving the copy with d's.
+// CacheSize, BufferWrites, MaxOpenFiles, SyncInterval and CoalesceReads
+// configure state the copy shares with d, so changes to them are logged as
+// warnings and ignored.
+func (d *Driver) With(opts ...Option) *Driver {
+	clone := *d
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt.apply(&clone.opts)
+		}
+	}
+	clone.opts.Locks = d.locks
+	if clone.opts.Logger == nil {
+		clone.opts.Logger = d.log
+	}
+	// Relocate only changes the directory between operations, and never
+	// once d is closed.
+	if leave, err := d.enter(); err == nil {
+		defer leave()
+	}
+	if err := clone.opts.normalize(*d.dir); err != nil {
+		d.log.Error("Ignoring options: %s\n", err)
+		clone.opts = d.opts
+	}
+	if fixed := clone.opts.keepShared(d.opts); len(fixed) > 0 {
+		d.log.Warn("Ignoring options a copy cannot change: %s\n", strings.Join(fixed, ", "))
+	}
+	clone.log = clone.opts.Logger
+	return &clone
+}
+
+// keepShared resets the options of o that configure state copies made by
+// With share to their values in shared, and returns the names of those that
+// differed.
+func (o *Options) keepShared(shared Options) []string {
+	var fixed []string
+	if o.CacheSize != shared.CacheSize {
+		o.CacheSize = shared.CacheSize
+		fixed = append(fixed, "CacheSize")
+	}
+	if o.BufferWrites != shared.BufferWrites || o.BufferMaxRecords != shared.BufferMaxRecords || o.BufferMaxDelay != shared.BufferMaxDelay {
+		o.BufferWrites, o.BufferMaxRecords, o.BufferMaxDelay = shared.BufferWrites, shared.BufferMaxRecords, shared.BufferMaxDelay
+		fixed = append(fixed, "BufferWrites")
+	}
+	if o.MaxOpenFiles != shared.MaxOpenFiles {
+		o.MaxOpenFiles = shared.MaxOpenFiles
+		fixed = append(fixed, "MaxOpenFiles")
+	}
+	if o.SyncInterval != shared.SyncInterval {
+		o.SyncInterval = shared.SyncInterval
+		fixed = append(fixed, "SyncInterval")
+	}
+	if o.CoalesceReads != shared.CoalesceReads {
+		o.CoalesceReads = shared.CoalesceReads
+		fixed = append(fixed, "CoalesceReads")
+	}
+	return fixed
+}
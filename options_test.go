@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWithReadOnlyClone(t *testing.T) {
+	d := newTestDriver(t)
+	view := d.With(WithReadOnly(true))
+
+	if err := view.Write("users", "Arnab", demoUsers()[0]); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("read-only clone Write returned %v, want ErrReadOnly", err)
+	}
+	if err := d.Write("users", "Arnab", demoUsers()[0]); err != nil {
+		t.Fatalf("parent Write failed after cloning: %v", err)
+	}
+
+	var user User
+	if err := view.Read("users", "Arnab", &user); err != nil || user.Name != "Arnab" {
+		t.Fatalf("clone Read of the parent's write returned %+v, %v", user, err)
+	}
+}
+
+func TestWithSharesLocks(t *testing.T) {
+	d := newTestDriver(t)
+	clone := d.With(WithDryRun(false))
+
+	if d.getOrCreateMutex("users") != clone.getOrCreateMutex("users") {
+		t.Fatal("clone has its own collection lock")
+	}
+
+	// Increments through either driver are serialized by the shared locks.
+	var wg sync.WaitGroup
+	for _, driver := range []*Driver{d, clone, d, clone} {
+		wg.Add(1)
+		go func(driver *Driver) {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				locked, unlock := driver.Lock("counters", "hits")
+				var n int
+				if err := locked.Read("counters", "hits", &n); err != nil && !errors.Is(err, ErrNotFound) {
+					t.Error(err)
+				}
+				if err := locked.Write("counters", "hits", n+1); err != nil {
+					t.Error(err)
+				}
+				unlock()
+			}
+		}(driver)
+	}
+	wg.Wait()
+
+	var n int
+	if err := d.Read("counters", "hits", &n); err != nil || n != 100 {
+		t.Fatalf("counter = %d, %v; want 100", n, err)
+	}
+}
+
+func TestWithNormalizes(t *testing.T) {
+	d := newTestDriver(t)
+
+	clone := d.With(&Options{DryRun: true})
+	if clone.opts.TempSuffix != ".tmp" || clone.opts.Compressor == nil || clone.log != d.log {
+		t.Fatalf("clone from a whole *Options was not normalized: %+v", clone.opts)
+	}
+	if err := clone.Write("users", "Arnab", demoUsers()[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// Options New would reject are ignored.
+	bad := d.With(WithLayout(Layout(99)))
+	if bad.opts.Layout != d.opts.Layout {
+		t.Fatalf("clone took the invalid layout %d", bad.opts.Layout)
+	}
+}
+
+func TestWithIgnoresSharedOptions(t *testing.T) {
+	logger := &testLogger{}
+	d := newTestDriver(t, WithLogger(logger), WithCacheSize(8))
+
+	clone := d.With(WithCacheSize(0), WithBufferWrites(10, time.Second), WithMaxOpenFiles(4), WithSyncInterval(time.Second), WithDryRun(true))
+	if clone.opts.CacheSize != 8 || clone.opts.BufferWrites || clone.opts.MaxOpenFiles != 0 || clone.opts.SyncInterval != 0 {
+		t.Fatalf("clone took options it cannot apply: %+v", clone.opts)
+	}
+	if !clone.opts.DryRun {
+		t.Fatal("clone dropped DryRun along with the ignored options")
+	}
+
+	warnings := logger.Warnings()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "CacheSize, BufferWrites, MaxOpenFiles, SyncInterval") {
+		t.Fatalf("warnings = %q", warnings)
+	}
+
+	d.With(WithDryRun(true))
+	if got := logger.Warnings(); len(got) != 1 {
+		t.Fatalf("a clone changing nothing shared warned: %q", got[1:])
+	}
+}
+
+func TestNewDefaults(t *testing.T) {
+	d := newTestDriver(t)
+
+	if d.opts.Layout != PerFile || d.opts.TempSuffix != ".tmp" || d.opts.MaxNameLength != 255 {
+		t.Fatalf("unexpected defaults %+v", d.opts)
+	}
+	if _, ok := d.opts.Compressor.(gzipCompressor); !ok {
+		t.Fatalf("default Compressor is %T, want gzip", d.opts.Compressor)
+	}
+	if d.opts.ReadOnly || d.opts.DryRun || d.opts.Compress {
+		t.Fatalf("behaviour options on by default: %+v", d.opts)
+	}
+}
+
+func TestNewOptions(t *testing.T) {
+	logger := &testLogger{}
+	d := openTestDriver(t, t.TempDir(), WithLogger(logger), WithTempSuffix(".part"), WithMaxNameLength(64))
+
+	if d.log != logger || d.opts.TempSuffix != ".part" || d.opts.MaxNameLength != 64 {
+		t.Fatalf("options not applied: %+v", d.opts)
+	}
+}
+
+func TestNewWholeOptions(t *testing.T) {
+	logger := &testLogger{}
+	d, err := New(t.TempDir(), &Options{Logger: logger, UseNumber: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	if d.log != logger || !d.opts.UseNumber || d.opts.TempSuffix != ".tmp" {
+		t.Fatalf("*Options not applied with defaults: %+v", d.opts)
+	}
+}
+
+func TestNewRejectsInvalidOptions(t *testing.T) {
+	_, err := New(t.TempDir(), WithLogger(&testLogger{}), WithLayout(SingleFile), WithCompress(true))
+	if err == nil {
+		t.Fatal("New accepted Compress with the SingleFile layout")
+	}
+}
+
+func TestNewInvalidCombinations(t *testing.T) {
+	dir := t.TempDir()
+	for name, tc := range map[string]struct {
+		options []Option
+		want    string
+	}{
+		"PathFor with SingleFile": {
+			[]Option{WithLayout(SingleFile), WithPathFor(shardByPrefix)},
+			"PathFor only applies to the PerFile layout",
+		},
+		"short encryption key": {
+			[]Option{WithEncryptFields("users", "Contact"), WithEncryptionKey([]byte("short"))},
+			"EncryptionKey of 5 bytes",
+		},
+		"read-only missing dir": {
+			[]Option{WithReadOnly(true), WithFallbackDir(dir)},
+			"does not exist",
+		},
+		"temp suffix like a record": {
+			[]Option{WithTempSuffix(".json")},
+			`TempSuffix ".json" cannot be told apart`,
+		},
+		"negative timeout": {
+			[]Option{WithOperationTimeout(-time.Second)},
+			"negative OperationTimeout",
+		},
+		"fallback is the database": {
+			[]Option{WithFallbackDir(filepath.Join(dir, "db"))},
+			"FallbackDir is the database directory",
+		},
+	} {
+		options := append([]Option{WithLogger(&testLogger{})}, tc.options...)
+		_, err := New(filepath.Join(dir, "db"), options...)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%v: New returned %v, want %q", name, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ReadAllParallel is ReadAll with the record reads spread across workers
+// goroutines. Records come back in the same order ReadAll returns them, and
+// the first read error aborts the remaining reads.
+func (d *Driver) ReadAllParallel(collection string, workers int) ([]string, error) {
+	leave, err := d.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	if collection == "" {
+		return nil, fmt.Errorf("missing collection - unable to read")
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	resources, read, err := d.tieredRecords(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		records  = make([]string, len(resources))
+		jobs     = make(chan int)
+		failed   = make(chan struct{})
+		once     sync.Once
+		firstErr error
+		wg       sync.WaitGroup
+	)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range jobs {
+				b, err := read(resources[i])
+				if err != nil {
+					once.Do(func() {
+						firstErr = err
+						close(failed)
+					})
+					continue
+				}
+				records[i] = string(b)
+			}
+		}()
+	}
+
+feed:
+	for i := range resources {
+		select {
+		case jobs <- i:
+		case <-failed:
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return records, nil
+}
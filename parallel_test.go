@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeEvents stores n small records in the events collection.
+func writeEvents(t testing.TB, d *Driver, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := d.Write("events", fmt.Sprintf("e%04d", i), map[string]int{"Seq": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadAllParallelMatchesReadAll(t *testing.T) {
+	d := newTestDriver(t)
+	writeEvents(t, d, 100)
+
+	want, err := d.ReadAll("events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, workers := range []int{0, 1, 4, 200} {
+		got, err := d.ReadAllParallel("events", workers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("ReadAllParallel with %d workers returned records out of order", workers)
+		}
+	}
+}
+
+func TestReadAllParallelError(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+	writeEvents(t, d, 20)
+
+	// A record that fails to decompress cannot be read.
+	if err := os.WriteFile(filepath.Join(dir, "events", "e0100.json.gz"), []byte("not gzip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ReadAllParallel("events", 4); err == nil {
+		t.Fatal("ReadAllParallel ignored an unreadable record")
+	}
+}
+
+func BenchmarkReadAll(b *testing.B) {
+	d := newTestDriver(b)
+	writeEvents(b, d, 1000)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := d.ReadAll("events"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadAllParallel(b *testing.B) {
+	d := newTestDriver(b)
+	writeEvents(b, d, 1000)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := d.ReadAllParallel("events", 8); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestReadAllParallelMaxOpenFiles(t *testing.T) {
+	d := newTestDriver(t, WithMaxOpenFiles(1))
+	writeEvents(t, d, 100)
+
+	want, err := d.ReadAll("events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.ReadAllParallel("events", 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatal("ReadAllParallel under MaxOpenFiles returned different records")
+	}
+
+	results, err := d.Stream(context.Background(), "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed := 0
+	for result := range results {
+		if result.Err != nil {
+			t.Fatal(result.Err)
+		}
+		streamed++
+	}
+	if streamed != 100 {
+		t.Fatalf("streamed %d records, want 100", streamed)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestApplyJSONPatch(t *testing.T) {
+	d := newTestDriver(t)
+	writeDemoUsers(t, d)
+
+	patch := []byte(`[
+		{"op": "test", "path": "/Company", "value": "DAPL"},
+		{"op": "replace", "path": "/Company", "value": "Acme"},
+		{"op": "copy", "from": "/Address/City", "path": "/HomeTown"},
+		{"op": "move", "from": "/Contact", "path": "/Phone"},
+		{"op": "remove", "path": "/Age"},
+		{"op": "add", "path": "/Tags", "value": ["a"]},
+		{"op": "add", "path": "/Tags/-", "value": "b"}
+	]`)
+	if err := d.ApplyJSONPatch("users", "Arnab", patch); err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]interface{}
+	if err := d.Read("users", "Arnab", &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"Company": "Acme", "HomeTown": "Kolkata", "Phone": "322444566",
+	}
+	for field, value := range want {
+		if got[field] != value {
+			t.Errorf("%v = %v, want %v", field, got[field], value)
+		}
+	}
+	if _, ok := got["Age"]; ok {
+		t.Error("Age was not removed")
+	}
+	if _, ok := got["Contact"]; ok {
+		t.Error("Contact was not moved")
+	}
+	if tags, ok := got["Tags"].([]interface{}); !ok || len(tags) != 2 || tags[1] != "b" {
+		t.Errorf("Tags = %v, want [a b]", got["Tags"])
+	}
+}
+
+func TestApplyJSONPatchFailedTest(t *testing.T) {
+	d := newTestDriver(t)
+	writeDemoUsers(t, d)
+
+	patch := []byte(`[
+		{"op": "replace", "path": "/Company", "value": "Acme"},
+		{"op": "test", "path": "/Name", "value": "Someone else"}
+	]`)
+	if err := d.ApplyJSONPatch("users", "Arnab", patch); err == nil {
+		t.Fatal("ApplyJSONPatch ignored a failing test op")
+	}
+
+	var user User
+	if err := d.Read("users", "Arnab", &user); err != nil || user != demoUsers()[0] {
+		t.Fatalf("record changed to %+v, %v after a failed patch", user, err)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+)
+
+// Pop reads a record into v and deletes it in one step under the collection
+// lock, so of several callers popping the same record only one gets it; the
+// others see ErrNotFound. A record that cannot be decoded into v is left in
+// place.
+func (d *Driver) Pop(collection, resource string, v interface{}) error {
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if collection == "" {
+		return fmt.Errorf("missing collection - unable to read")
+	}
+	if resource == "" {
+		return fmt.Errorf("missing resource - unable to read record (no name)")
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("%w: got %T", ErrNotPointer, v)
+	}
+
+	return d.withTimeout(func() error {
+		mutex := d.getOrCreateMutex(collection)
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return d.pop(collection, resource, v)
+	})
+}
+
+// PopAny pops an arbitrary record of collection into v and returns its
+// resource, or ErrNotFound if the collection is empty.
+func (d *Driver) PopAny(collection string, v interface{}) (string, error) {
+	if d.opts.ReadOnly {
+		return "", ErrReadOnly
+	}
+	if collection == "" {
+		return "", fmt.Errorf("missing collection - unable to read")
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return "", fmt.Errorf("%w: got %T", ErrNotPointer, v)
+	}
+
+	var resource string
+
+	err := d.withTimeout(func() error {
+		mutex := d.getOrCreateMutex(collection)
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		resources, err := d.resources(collection)
+		if err == nil && len(resources) == 0 {
+			err = fmt.Errorf("%w: %v is empty", ErrNotFound, collection)
+		}
+		if os.IsNotExist(err) {
+			err = fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
+		if err != nil {
+			return err
+		}
+
+		resource = resources[0]
+		return d.pop(collection, resource, v)
+	})
+	if err != nil {
+		return "", err
+	}
+	return resource, nil
+}
+
+// pop is Pop with the collection lock already held.
+func (d *Driver) pop(collection, resource string, v interface{}) error {
+	b, err := d.readRecord(collection, resource)
+	if err != nil {
+		return err
+	}
+	if err := d.decode(b, v); err != nil {
+		return decodeError(collection, resource, b, err)
+	}
+	return d.deleteRecord(collection, resource)
+}
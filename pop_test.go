@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestPopOnlyOnce(t *testing.T) {
+	d := newTestDriver(t)
+	for round := 0; round < 20; round++ {
+		writeDemoUsers(t, d)
+
+		var wg sync.WaitGroup
+		errs := make([]error, 2)
+		users := make([]User, 2)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = d.Pop("users", "Arnab", &users[i])
+			}(i)
+		}
+		wg.Wait()
+
+		won := 0
+		for i, err := range errs {
+			switch {
+			case err == nil:
+				won++
+				if users[i].Name != "Arnab" {
+					t.Fatalf("popped %+v", users[i])
+				}
+			case !errors.Is(err, ErrNotFound):
+				t.Fatal(err)
+			}
+		}
+		if won != 1 {
+			t.Fatalf("%d callers popped the same record", won)
+		}
+	}
+}
+
+func TestPopAny(t *testing.T) {
+	d := newTestDriver(t)
+	writeDemoUsers(t, d)
+
+	seen := make(map[string]bool)
+	for range demoUsers() {
+		var user User
+		resource, err := d.PopAny("users", &user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Name != resource || seen[resource] {
+			t.Fatalf("PopAny returned %v with %+v", resource, user)
+		}
+		seen[resource] = true
+	}
+
+	var user User
+	if _, err := d.PopAny("users", &user); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("PopAny of an empty collection returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestPopUndecodable(t *testing.T) {
+	d := newTestDriver(t)
+	writeDemoUsers(t, d)
+
+	var n int
+	if err := d.Pop("users", "Arnab", &n); err == nil {
+		t.Fatal("Pop decoded a user into an int")
+	}
+	var user User
+	if err := d.Read("users", "Arnab", &user); err != nil {
+		t.Fatalf("failed Pop removed the record: %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import "context"
+
+// RateLimiter throttles reads and writes for Options.RateLimiter and
+// Options.CollectionRateLimits. *rate.Limiter from golang.org/x/time/rate
+// satisfies it.
+type RateLimiter interface {
+	// Wait blocks until the operation may proceed, or returns ctx.Err()
+	// if ctx is done first.
+	Wait(ctx context.Context) error
+}
+
+// ReadContext is Read, except that waiting on Options.RateLimiter gives up
+// with ctx.Err() once ctx is done.
+func (d *Driver) ReadContext(ctx context.Context, collection, resource string, v interface{}) error {
+	return d.read(ctx, collection, resource, v)
+}
+
+// WriteContext is Write, except that waiting on Options.RateLimiter gives up
+// with ctx.Err() once ctx is done.
+func (d *Driver) WriteContext(ctx context.Context, collection, resource string, v interface{}) error {
+	_, err := d.writeEx(ctx, collection, resource, v)
+	return err
+}
+
+// throttle waits on the collection's limiter and then the global one.
+func (d *Driver) throttle(ctx context.Context, collection string) error {
+	if limiter := d.opts.CollectionRateLimits[collection]; limiter != nil {
+		if err := limiter.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	if d.opts.RateLimiter != nil {
+		return d.opts.RateLimiter.Wait(ctx)
+	}
+	return nil
+}
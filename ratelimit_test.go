@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// tokenLimiter lets one operation through per token sent on it.
+type tokenLimiter chan struct{}
+
+func (l tokenLimiter) Wait(ctx context.Context) error {
+	select {
+	case <-l:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestRateLimiterThrottles(t *testing.T) {
+	limiter := make(tokenLimiter, 1)
+	d := newTestDriver(t, WithRateLimiter(limiter))
+
+	limiter <- struct{}{}
+	if err := d.Write("users", "Arnab", User{Name: "Arnab"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Out of tokens, the next operation waits for one.
+	done := make(chan error, 1)
+	var user User
+	go func() { done <- d.Read("users", "Arnab", &user) }()
+	select {
+	case err := <-done:
+		t.Fatalf("Read did not wait on the limiter: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	limiter <- struct{}{}
+	if err := <-done; err != nil || user.Name != "Arnab" {
+		t.Fatalf("Read returned %+v, %v", user, err)
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	d := newTestDriver(t, WithRateLimiter(make(tokenLimiter)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := d.WriteContext(ctx, "users", "Arnab", User{Name: "Arnab"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WriteContext returned %v, want context.DeadlineExceeded", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	var user User
+	if err := d.ReadContext(ctx, "users", "Arnab", &user); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ReadContext returned %v, want context.Canceled", err)
+	}
+
+	if keys, err := d.With(WithRateLimiter(nil)).Keys("users"); err != nil || len(keys) != 0 {
+		t.Fatalf("cancelled write stored %v, %v", keys, err)
+	}
+}
+
+func TestCollectionRateLimit(t *testing.T) {
+	limiter := make(tokenLimiter)
+	d := newTestDriver(t, WithCollectionRateLimit("events", limiter))
+
+	// Other collections are not held up by the events limiter.
+	if err := d.Write("users", "Arnab", User{Name: "Arnab"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := d.WriteContext(ctx, "events", "e0000", 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WriteContext to a limited collection returned %v, want context.DeadlineExceeded", err)
+	}
+}
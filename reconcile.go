@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// SyncKind is what Reconcile suggests doing with a record.
+type SyncKind string
+
+const (
+	// SyncCopyAToB means the record exists in a only.
+	SyncCopyAToB SyncKind = "copy_a_to_b"
+	// SyncCopyBToA means the record exists in b only.
+	SyncCopyBToA SyncKind = "copy_b_to_a"
+	// SyncConflict means the record exists in both with different content.
+	SyncConflict SyncKind = "conflict"
+)
+
+// SyncAction is a single step of the plan Reconcile returns.
+type SyncAction struct {
+	Resource string
+	Kind     SyncKind
+}
+
+// Reconcile compares collection between a and b and returns what it would
+// take to bring them in sync, in resource name order, without applying any
+// of it. Records are compared by content, as Read sees it. Neither driver
+// remembers an earlier sync, so a record that differs between the two is
+// always reported as a conflict, for the caller to resolve, e.g. by ModTime.
+// A collection missing from one side counts as empty.
+func Reconcile(a, b *Driver, collection string) ([]SyncAction, error) {
+	if a == nil || b == nil {
+		return nil, fmt.Errorf("missing driver - unable to reconcile")
+	}
+	if collection == "" {
+		return nil, fmt.Errorf("missing collection - unable to reconcile")
+	}
+
+	leaveA, err := a.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer leaveA()
+	leaveB, err := b.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer leaveB()
+
+	aNames, aRead, err := a.records(collection)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	bNames, bRead, err := b.records(collection)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	var actions []SyncAction
+
+	// Both lists are sorted, so they merge in a single pass.
+	i, j := 0, 0
+	for i < len(aNames) || j < len(bNames) {
+		switch {
+		case j == len(bNames) || i < len(aNames) && aNames[i] < bNames[j]:
+			actions = append(actions, SyncAction{aNames[i], SyncCopyAToB})
+			i++
+		case i == len(aNames) || bNames[j] < aNames[i]:
+			actions = append(actions, SyncAction{bNames[j], SyncCopyBToA})
+			j++
+		default:
+			resource := aNames[i]
+			i++
+			j++
+
+			ab, err := aRead(resource)
+			if err != nil {
+				return nil, err
+			}
+			bb, err := bRead(resource)
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(ab, bb) {
+				actions = append(actions, SyncAction{resource, SyncConflict})
+			}
+		}
+	}
+	return actions, nil
+}
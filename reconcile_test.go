@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestReconcile(t *testing.T) {
+	a := newTestDriver(t, WithCompress(true))
+	b := newTestDriver(t)
+	writeDemoUsers(t, a)
+	writeDemoUsers(t, b)
+
+	// Arnab and John changed on one side each, Harry is only in a and
+	// Zoe only in b. The rest, stored compressed in a and plain in b,
+	// are the same.
+	if err := a.SetField("users", "Arnab", "Company", "Acme"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetField("users", "John", "Company", "Initech"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete("users", "Harry"); err != nil {
+		t.Fatal(err)
+	}
+	mustWrite(t, b, "users", map[string]interface{}{"Zoe": User{Name: "Zoe"}})
+
+	actions, err := Reconcile(a, b, "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[{Arnab conflict} {Harry copy_a_to_b} {John conflict} {Zoe copy_b_to_a}]"
+	if fmt.Sprint(actions) != want {
+		t.Fatalf("Reconcile returned %v, want %v", actions, want)
+	}
+
+	// Planning applies nothing.
+	var user User
+	if err := b.Read("users", "Harry", &user); err == nil {
+		t.Fatal("Reconcile copied Harry to b")
+	}
+}
+
+func TestReconcileMissingCollection(t *testing.T) {
+	a := newTestDriver(t)
+	b := newTestDriver(t)
+	writeEvents(t, a, 2)
+
+	actions, err := Reconcile(a, b, "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[{e0000 copy_a_to_b} {e0001 copy_a_to_b}]"; fmt.Sprint(actions) != want {
+		t.Fatalf("Reconcile returned %v, want %v", actions, want)
+	}
+
+	if actions, err := Reconcile(a, b, "missing"); err != nil || len(actions) != 0 {
+		t.Fatalf("Reconcile of a collection neither has returned %v, %v", actions, err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Recover cleans up after writes that were interrupted by a crash. A
+// leftover temp file of a record, under any of the extensions records are
+// stored with, is promoted in its place if the record is missing under all
+// of them and the temp file holds valid JSON; every other temp file, such as
+// one of a blob, is discarded.
+func (d *Driver) Recover() error {
+	leave, err := d.enter()
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+
+	collections, err := d.collections()
+	if d.opts.Layout == SingleFile {
+		collections, err = d.pendingCollections()
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, collection := range collections {
+		if err := d.recover(collection); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pendingCollections lists the SingleFile collections with a temp file,
+// including ones whose very first write was interrupted.
+func (d *Driver) pendingCollections() ([]string, error) {
+	files, err := ioutil.ReadDir(*d.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var collections []string
+
+	for _, file := range files {
+		suffix := jsonExt + d.opts.TempSuffix
+		if file.Mode().IsRegular() && strings.HasSuffix(file.Name(), suffix) {
+			collections = append(collections, strings.TrimSuffix(file.Name(), suffix))
+		}
+	}
+	return collections, nil
+}
+
+func (d *Driver) recover(collection string) error {
+	mutex := d.getOrCreateMutex(collection)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	temps, err := d.tempFiles(collection)
+	if err != nil {
+		return err
+	}
+
+	for _, temp := range temps {
+		tmpPath := temp.path
+		fnlPath := strings.TrimSuffix(tmpPath, d.opts.TempSuffix)
+
+		if d.promotable(tmpPath, fnlPath) {
+			if d.opts.DryRun {
+				d.log.Info("Would promote '%s'\n", tmpPath)
+				continue
+			}
+			d.log.Info("Promoting '%s'\n", tmpPath)
+			if err := os.Rename(tmpPath, fnlPath); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if d.opts.DryRun {
+			d.log.Info("Would discard '%s'\n", tmpPath)
+			continue
+		}
+		d.log.Info("Discarding '%s'\n", tmpPath)
+		if err := os.Remove(tmpPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Driver) promotable(tmpPath, fnlPath string) bool {
+	ext, ok := d.recordExt(fnlPath)
+	if !ok {
+		return false
+	}
+	base := strings.TrimSuffix(fnlPath, ext)
+	for _, ext := range d.extensions() {
+		if _, err := os.Lstat(base + ext); !os.IsNotExist(err) {
+			return false
+		}
+	}
+
+	b, err := d.readFile(tmpPath)
+	return err == nil && json.Valid(b)
+}
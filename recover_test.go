@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecover(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+	writeDemoUsers(t, d)
+
+	users := filepath.Join(dir, "users")
+	leftovers := map[string]string{
+		// No record: promoted.
+		"Zed.json.tmp": `{"Name": "Zed"}`,
+		// A stale record is there already: discarded.
+		"Arnab.json.tmp": `{"Name": "Newer"}`,
+		// Truncated by the crash: discarded.
+		"Amy.json.tmp": `{"Name": "A`,
+		// The record exists under another extension: discarded.
+		"John.json.gz.tmp": `{"Name": "John"}`,
+	}
+	for name, content := range leftovers {
+		if err := os.WriteFile(filepath.Join(users, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := d.Recover(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name := range leftovers {
+		if _, err := os.Stat(filepath.Join(users, name)); !os.IsNotExist(err) {
+			t.Errorf("%v still there after Recover: %v", name, err)
+		}
+	}
+
+	var user User
+	if err := d.Read("users", "Zed", &user); err != nil || user.Name != "Zed" {
+		t.Errorf("promoted Zed reads as %+v, %v", user, err)
+	}
+	if err := d.Read("users", "Arnab", &user); err != nil || user.Name != "Arnab" {
+		t.Errorf("Arnab reads as %+v, %v; want the stale record kept", user, err)
+	}
+	if _, err := os.Stat(filepath.Join(users, "Amy.json")); !os.IsNotExist(err) {
+		t.Errorf("truncated Amy was promoted: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(users, "John.json.gz")); !os.IsNotExist(err) {
+		t.Errorf("John was promoted next to John.json: %v", err)
+	}
+}
+
+func TestRecoverDryRun(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+	if err := d.EnsureCollection("users"); err != nil {
+		t.Fatal(err)
+	}
+	tmp := filepath.Join(dir, "users", "Zed.json.tmp")
+	if err := os.WriteFile(tmp, []byte(`{"Name": "Zed"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.With(WithDryRun(true)).Recover(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tmp); err != nil {
+		t.Fatalf("dry run moved the temp file: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Relocate moves the database to newDir, which must not exist or be empty,
+// and carries on there. It waits for the operations in flight on the driver
+// and its copies, holds new ones off until it is done, flushes buffered
+// writes and holds every collection lock. The directory is renamed, or
+// where that fails, e.g. across devices, copied and then removed. Temp
+// files from interrupted writes are not copied. On failure the database is
+// left where it was. Options.FallbackDir and Options.ReadDirs are not
+// moved.
+func (d *Driver) Relocate(newDir string) error {
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if newDir == "" {
+		return fmt.Errorf("missing directory - unable to relocate")
+	}
+	newDir = filepath.Clean(newDir)
+
+	resume, err := d.life.pause()
+	if err != nil {
+		return err
+	}
+	defer resume()
+
+	if err := d.flushAll(); err != nil {
+		return err
+	}
+
+	collections, err := d.collections()
+	if err != nil {
+		return err
+	}
+
+	unlock := d.lockCollections(collections...)
+	defer unlock()
+
+	oldDir := *d.dir
+	if same, err := sameDir(oldDir, newDir); err != nil || same {
+		if err == nil {
+			err = fmt.Errorf("invalid directory - %v is already the database", newDir)
+		}
+		return err
+	}
+	if rel, err := filepath.Rel(oldDir, newDir); err == nil && filepath.IsLocal(rel) {
+		return fmt.Errorf("invalid directory - %v is inside the database", newDir)
+	}
+
+	entries, err := os.ReadDir(newDir)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	case len(entries) > 0:
+		return fmt.Errorf("%w: directory %v is not empty", ErrExists, newDir)
+	default:
+		if err := os.Remove(newDir); err != nil {
+			return diskError(err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(newDir), 0755); err != nil {
+		return diskError(err)
+	}
+
+	if err := os.Rename(oldDir, newDir); err != nil {
+		if err := d.copyDir(oldDir, newDir); err != nil {
+			os.RemoveAll(newDir)
+			return err
+		}
+		if err := os.RemoveAll(oldDir); err != nil {
+			d.log.Warn("Unable to remove '%s' after copying it to '%s': %s\n", oldDir, newDir, err)
+		}
+	}
+
+	*d.dir = newDir
+	d.log.Info("Relocated '%s' to '%s'\n", oldDir, newDir)
+	return nil
+}
+
+// sameDir reports whether a and b name the same directory, b possibly not
+// existing yet.
+func sameDir(a, b string) (bool, error) {
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	fb, err := os.Stat(b)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(fa, fb), nil
+}
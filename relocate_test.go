@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRelocate(t *testing.T) {
+	oldDir := t.TempDir()
+	d := openTestDriver(t, oldDir, WithBufferWrites(100, 0))
+	writeDemoUsers(t, d)
+	copied := d.With(WithUseNumber(true))
+
+	newDir := filepath.Join(t.TempDir(), "moved")
+	if err := d.Relocate(newDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(oldDir); !os.IsNotExist(err) {
+		t.Fatalf("Relocate left %v behind: %v", oldDir, err)
+	}
+
+	// Buffered writes were flushed before the move.
+	records, err := d.ReadAll("users")
+	if err != nil || len(records) != len(demoUsers()) {
+		t.Fatalf("ReadAll after Relocate returned %d records, %v", len(records), err)
+	}
+
+	// Later writes, and those of copies, land in the new directory.
+	if err := copied.Write("users", "Zoe", User{Name: "Zoe"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := copied.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(newDir, "users", "Zoe.json")); err != nil {
+		t.Fatalf("write after Relocate went elsewhere: %v", err)
+	}
+}
+
+func TestRelocateRejects(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+	writeDemoUsers(t, d)
+
+	full := t.TempDir()
+	if err := os.WriteFile(filepath.Join(full, "keep"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Relocate(full); !errors.Is(err, ErrExists) {
+		t.Fatalf("Relocate onto a non-empty directory returned %v, want ErrExists", err)
+	}
+	for _, target := range []string{dir, filepath.Join(dir, "users", "inner")} {
+		if err := d.Relocate(target); err == nil {
+			t.Errorf("Relocate to %v succeeded", target)
+		}
+	}
+
+	var user User
+	if err := d.Read("users", "Arnab", &user); err != nil || user.Name != "Arnab" {
+		t.Fatalf("Read after rejected moves returned %+v, %v", user, err)
+	}
+}
+
+func TestRelocateAcrossDevices(t *testing.T) {
+	// /dev/shm is usually a tmpfs apart from the temp dir, so the rename
+	// fails and the database is copied instead.
+	target, err := os.MkdirTemp("/dev/shm", "relocate")
+	if err != nil {
+		t.Skip("no second filesystem to move to")
+	}
+	t.Cleanup(func() { os.RemoveAll(target) })
+
+	oldDir := t.TempDir()
+	d := openTestDriver(t, oldDir)
+	writeDemoUsers(t, d)
+	if err := os.WriteFile(filepath.Join(oldDir, "users", "Paul.json.tmp"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	newDir := filepath.Join(target, "db")
+	if err := d.Relocate(newDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(oldDir); !os.IsNotExist(err) {
+		t.Fatalf("copying Relocate left %v behind: %v", oldDir, err)
+	}
+	if _, err := os.Stat(filepath.Join(newDir, "users", "Paul.json.tmp")); !os.IsNotExist(err) {
+		t.Fatalf("copying Relocate copied a temp file: %v", err)
+	}
+	records, err := d.ReadAll("users")
+	if err != nil || len(records) != len(demoUsers()) {
+		t.Fatalf("ReadAll after a copying Relocate returned %d records, %v", len(records), err)
+	}
+}
+
+func TestRelocateConcurrent(t *testing.T) {
+	d := newTestDriver(t)
+	writeEvents(t, d, 10)
+
+	// Relocate waits for a moment with nothing in flight, so the
+	// operations leave short gaps between them.
+	var (
+		wg      sync.WaitGroup
+		stop    = make(chan struct{})
+		written int64
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			case <-time.After(100 * time.Microsecond):
+			}
+			if err := d.Write("events", fmt.Sprintf("w%04d", i), i); err != nil {
+				t.Error(err)
+				return
+			}
+			atomic.AddInt64(&written, 1)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(100 * time.Microsecond):
+			}
+			if _, err := d.Keys("events"); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	dirs := []string{filepath.Join(t.TempDir(), "a"), filepath.Join(t.TempDir(), "b")}
+	for i, dir := range dirs {
+		for atomic.LoadInt64(&written) < int64(50*(i+1)) && !t.Failed() {
+			time.Sleep(time.Millisecond)
+		}
+		if err := d.Relocate(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	keys, err := d.Keys("events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(keys)) != 10+atomic.LoadInt64(&written) {
+		t.Fatalf("%d records after %d writes around two moves", len(keys), 10+written)
+	}
+	entries, err := os.ReadDir(filepath.Join(dirs[1], "events"))
+	if err != nil || len(entries) != len(keys) {
+		t.Fatalf("%v holds %d entries, %v", dirs[1], len(entries), err)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ReplaceCollection makes records the entire contents of collection, keyed
+// by resource, under the collection lock. Under the PerFile layout every new
+// record is first written to a temp file, then records missing from the new
+// set are removed and the temps renamed into place, keeping the window in
+// which the collection is inconsistent short. Under SingleFile the
+// replacement is a single atomic write.
+func (d *Driver) ReplaceCollection(collection string, records map[string]interface{}) error {
+	leave, err := d.enter()
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if collection == "" {
+		return fmt.Errorf("missing collection - no place to save records")
+	}
+
+	encoded := make(map[string][]byte, len(records))
+	for resource, v := range records {
+		if resource == "" {
+			return fmt.Errorf("missing resource - unable to save record (no name)")
+		}
+		if err := d.checkName(collection, resource); err != nil {
+			return err
+		}
+		b, err := d.marshal(collection, resource, v)
+		if err != nil {
+			return err
+		}
+		encoded[resource] = b
+	}
+
+	return d.withTimeout(func() error {
+		mutex := d.getOrCreateMutex(collection)
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		d.warnIfUnknown(collection)
+
+		if d.opts.DryRun {
+			return d.logReplace(collection, encoded)
+		}
+		d.buffer.discard(collection, "")
+		d.forget(collection, "")
+		if d.opts.Layout == SingleFile {
+			return d.replaceCollectionFile(collection, encoded)
+		}
+		return d.replaceRecords(collection, encoded)
+	})
+}
+
+func (d *Driver) replaceRecords(collection string, encoded map[string][]byte) error {
+	type staged struct{ resource, path, tmpPath string }
+
+	var temps []staged
+	discard := func() {
+		for _, temp := range temps {
+			os.Remove(temp.tmpPath)
+		}
+	}
+
+	for _, resource := range sortedResources(encoded) {
+		path := d.recordFile(collection, resource)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			discard()
+			return diskError(err)
+		}
+
+		path, tmpPath, err := d.stageFile(path, encoded[resource])
+		if err != nil {
+			discard()
+			return err
+		}
+		temps = append(temps, staged{resource, path, tmpPath})
+	}
+
+	existing, err := d.resources(collection)
+	if err != nil && !os.IsNotExist(err) {
+		discard()
+		return err
+	}
+	var removed []string
+	for _, resource := range existing {
+		if _, ok := encoded[resource]; ok {
+			continue
+		}
+		removed = append(removed, resource)
+
+		path, _, err := d.stat(collection, resource)
+		if err == nil {
+			err = os.Remove(path)
+		}
+		if err == nil {
+			err = d.removeSidecars(collection, resource)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			discard()
+			return err
+		}
+	}
+
+	for i, temp := range temps {
+		if err := replaceFile(temp.tmpPath, temp.path); err != nil {
+			temps = temps[i+1:]
+			discard()
+			return err
+		}
+		d.syncer.markDirty(temp.path)
+		if err := d.removeStale(collection, temp.resource); err != nil {
+			return err
+		}
+	}
+
+	if err := d.logChange(collection, true, removed...); err != nil {
+		return err
+	}
+	return d.logChange(collection, false, sortedResources(encoded)...)
+}
+
+func (d *Driver) replaceCollectionFile(collection string, encoded map[string][]byte) error {
+	existing, err := d.readCollectionFile(collection)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	records := make(map[string]json.RawMessage, len(encoded))
+	for resource, b := range encoded {
+		records[resource] = json.RawMessage(b)
+	}
+	if err := d.writeCollectionFile(collection, records); err != nil {
+		return err
+	}
+
+	var removed []string
+	for _, resource := range sortedKeys(existing) {
+		if _, ok := records[resource]; ok {
+			continue
+		}
+		removed = append(removed, resource)
+		if err := d.removeSidecars(collection, resource); err != nil {
+			return err
+		}
+	}
+
+	if err := d.logChange(collection, true, removed...); err != nil {
+		return err
+	}
+	return d.logChange(collection, false, sortedResources(encoded)...)
+}
+
+func (d *Driver) logReplace(collection string, encoded map[string][]byte) error {
+	existing, err := d.resources(collection)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, resource := range existing {
+		if _, ok := encoded[resource]; !ok {
+			d.log.Info("Would delete '%s/%s'\n", collection, resource)
+		}
+	}
+	for _, resource := range sortedResources(encoded) {
+		d.log.Info("Would write '%s/%s'\n", collection, resource)
+	}
+	return nil
+}
+
+func sortedResources[V any](records map[string]V) []string {
+	resources := make([]string, 0, len(records))
+	for resource := range records {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+	return resources
+}
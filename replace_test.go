@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestReplaceCollection(t *testing.T) {
+	for name, layout := range layouts {
+		t.Run(name, func(t *testing.T) {
+			d := newTestDriver(t, WithLayout(layout))
+			writeDemoUsers(t, d)
+
+			err := d.ReplaceCollection("users", map[string]interface{}{
+				"Arnab": User{Name: "Arnab", Company: "Acme"},
+				"Zed":   User{Name: "Zed"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			keys, err := d.Keys("users")
+			if err != nil || fmt.Sprint(keys) != "[Arnab Zed]" {
+				t.Fatalf("Keys after replacing = %v, %v", keys, err)
+			}
+			var user User
+			if err := d.Read("users", "Arnab", &user); err != nil || user.Company != "Acme" {
+				t.Fatalf("Arnab reads as %+v, %v", user, err)
+			}
+		})
+	}
+}
+
+func TestReplaceCollectionInvalid(t *testing.T) {
+	d := newTestDriver(t)
+	writeDemoUsers(t, d)
+
+	// A record that cannot be stored fails the whole replacement up front.
+	err := d.ReplaceCollection("users", map[string]interface{}{
+		"Zed": User{Name: "Zed"},
+		"":    User{Name: "Nameless"},
+	})
+	if err == nil {
+		t.Fatal("ReplaceCollection accepted a record without a name")
+	}
+	if keys, _ := d.Keys("users"); len(keys) != len(demoUsers()) {
+		t.Fatalf("failed replacement changed the collection to %v", keys)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestCollectionVersion(t *testing.T) {
+	for name, layout := range layouts {
+		t.Run(name, func(t *testing.T) {
+			d := newTestDriver(t, WithLayout(layout))
+			writeDemoUsers(t, d)
+
+			if version, err := d.CollectionVersion("users"); err != nil || version != 0 {
+				t.Fatalf("CollectionVersion before any was set returned %v, %v", version, err)
+			}
+			for _, version := range []int{3, 4} {
+				if err := d.SetCollectionVersion("users", version); err != nil {
+					t.Fatal(err)
+				}
+				if got, err := d.CollectionVersion("users"); err != nil || got != version {
+					t.Fatalf("CollectionVersion returned %v, %v, want %v", got, err, version)
+				}
+			}
+
+			keys, err := d.Keys("users")
+			if err != nil || len(keys) != len(demoUsers()) {
+				t.Fatalf("Keys returned %v, %v", keys, err)
+			}
+			records, err := d.ReadAll("users")
+			if err != nil || len(records) != len(demoUsers()) {
+				t.Fatalf("ReadAll returned %d records, %v", len(records), err)
+			}
+			overview, err := d.Overview()
+			if err != nil || len(overview) != 1 || overview["users"] != len(demoUsers()) {
+				t.Fatalf("Overview returned %v, %v", overview, err)
+			}
+			all, err := d.AllKeys()
+			if err != nil || len(all["users"]) != len(demoUsers()) {
+				t.Fatalf("AllKeys returned %v, %v", all, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSetFieldNotObject(t *testing.T) {
+	d := newTestDriver(t)
+	if err := d.Write("lists", "primes", []int{2, 3, 5, 7}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := d.SetField("lists", "primes", "count", 4)
+	if !errors.Is(err, ErrNotObject) {
+		t.Fatalf("SetField on an array returned %v, want ErrNotObject", err)
+	}
+
+	var primes []int
+	if err := d.Read("lists", "primes", &primes); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(primes) != "[2 3 5 7]" {
+		t.Fatalf("array changed to %v after the failed SetField", primes)
+	}
+}
+
+func TestSetFieldNested(t *testing.T) {
+	d := newTestDriver(t)
+	writeDemoUsers(t, d)
+
+	if err := d.SetField("users", "Arnab", "Address.City", "Delhi"); err != nil {
+		t.Fatal(err)
+	}
+	var user User
+	if err := d.Read("users", "Arnab", &user); err != nil {
+		t.Fatal(err)
+	}
+	if user.Address.City != "Delhi" || user.Address.State != "W.B." || user.Company != "DAPL" {
+		t.Fatalf("SetField left %+v", user)
+	}
+
+	if err := d.SetField("users", "Arnab", "Company.Name", "x"); !errors.Is(err, ErrNotObject) {
+		t.Fatalf("SetField through a string returned %v, want ErrNotObject", err)
+	}
+}
+
+func TestSetFieldCreatesPath(t *testing.T) {
+	d := newTestDriver(t)
+	writeDemoUsers(t, d)
+
+	if err := d.SetField("users", "Arnab", "Address.Geo.Lat", 22.57); err != nil {
+		t.Fatal(err)
+	}
+	var user struct {
+		Company string
+		Address map[string]interface{}
+	}
+	if err := d.Read("users", "Arnab", &user); err != nil {
+		t.Fatal(err)
+	}
+	geo, _ := user.Address["Geo"].(map[string]interface{})
+	if geo["Lat"] != 22.57 || user.Address["City"] != "Kolkata" || fmt.Sprint(user.Address["PinCode"]) != "755855" || user.Company != "DAPL" {
+		t.Fatalf("SetField left %+v", user)
+	}
+
+	for _, path := range []string{"", "Address.", ".City", "Address..City"} {
+		if err := d.SetField("users", "Arnab", path, "x"); err == nil {
+			t.Errorf("SetField accepted the path %q", path)
+		}
+	}
+	if err := d.SetField("users", "Nobody", "Name", "x"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("SetField on a missing record returned %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Snapshot copies every collection into destDir while holding all of their
+// locks, so the copy reflects a single point in time. Their changelogs from
+// Options.TrackChanges are copied along, so Changes keeps counting from the
+// same sequence in the copy; those of collections since deleted are not.
+// Temp files from in-progress or interrupted writes are left out.
+func (d *Driver) Snapshot(destDir string) error {
+	leave, err := d.enter()
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	collections, err := d.collections()
+	if err != nil {
+		return err
+	}
+
+	unlock := d.lockCollections(collections...)
+	defer unlock()
+
+	destDir = filepath.Clean(destDir)
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return diskError(err)
+	}
+
+	for _, collection := range collections {
+		if d.opts.Layout == SingleFile {
+			err = copyFile(d.collectionFile(collection), filepath.Join(destDir, collection+".json"))
+		} else {
+			err = d.copyDir(filepath.Join(*d.dir, collection), filepath.Join(destDir, collection))
+		}
+		if err != nil {
+			return err
+		}
+		if err := d.copyChangelog(collection, destDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyChangelog copies the changelog of collection, if it has one, into the
+// same place under destDir. The caller must hold the collection lock.
+func (d *Driver) copyChangelog(collection, destDir string) error {
+	src := d.changelogFile(collection)
+	rel, err := filepath.Rel(*d.dir, src)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return nil
+	}
+
+	dst := filepath.Join(destDir, rel)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return diskError(err)
+	}
+	return copyFile(src, dst)
+}
+
+func (d *Driver) copyDir(src, dst string) error {
+	src, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return err
+	}
+
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case fi.IsDir():
+			return os.MkdirAll(target, 0755)
+		case !fi.Mode().IsRegular(), strings.HasSuffix(fi.Name(), d.opts.TempSuffix):
+			return nil
+		}
+		return copyFile(path, target)
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return diskError(err)
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return diskError(err)
+	}
+	return diskError(out.Close())
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSnapshotDuringWrites(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+	writeDemoUsers(t, d)
+	if err := os.WriteFile(filepath.Join(dir, "users", "Zed.json.tmp"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeEvents(t, d, 1)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// Every record is written whole, so a snapshot catching one
+				// half-written would fail to decode it.
+				payload := strings.Repeat(fmt.Sprint(w), 4096)
+				if err := d.Write("events", fmt.Sprintf("w%d-%03d", w, i%50), map[string]string{"Payload": payload}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+
+	dest := filepath.Join(t.TempDir(), "snapshot")
+	for i := 0; i < 5; i++ {
+		if err := d.Snapshot(dest); err != nil {
+			t.Error(err)
+			break
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	err := filepath.Walk(dest, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && strings.HasSuffix(path, ".tmp") {
+			t.Errorf("snapshot contains temp file %v", path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := openTestDriver(t, dest)
+	records, err := snapshot.ReadAllResults("events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if record.Err != nil {
+			t.Errorf("snapshot of %v is broken: %v", record.Resource, record.Err)
+		}
+	}
+	users, err := snapshot.ReadAll("users")
+	if err != nil || len(users) != len(demoUsers()) {
+		t.Fatalf("snapshot has %d users, %v", len(users), err)
+	}
+}
+
+func TestSnapshotKeepsChangelogs(t *testing.T) {
+	d := newTestDriver(t, WithTrackChanges(true))
+	writeDemoUsers(t, d)
+
+	_, seq, err := d.Changes("users", 0)
+	if err != nil || seq == 0 {
+		t.Fatalf("Changes returned seq %d, %v", seq, err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "snapshot")
+	if err := d.Snapshot(dest); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := openTestDriver(t, dest, WithTrackChanges(true))
+	if _, got, err := snapshot.Changes("users", 0); err != nil || got != seq {
+		t.Fatalf("snapshot Changes returned seq %d, %v; want %d", got, err, seq)
+	}
+	if err := snapshot.Write("users", "Zed", User{Name: "Zed"}); err != nil {
+		t.Fatal(err)
+	}
+	changes, _, err := snapshot.Changes("users", seq)
+	if err != nil || len(changes) != 1 || changes[0].Seq != seq+1 {
+		t.Fatalf("snapshot logged %+v, %v; want seq %d", changes, err, seq+1)
+	}
+}
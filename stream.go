@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"unicode/utf8"
+)
+
+// RecordResult is a single record emitted by Stream.
+type RecordResult struct {
+	Resource string
+	Raw      []byte
+	Err      error
+}
+
+// Stream emits every record in collection on the returned channel, which is
+// closed once all records are sent or ctx is cancelled. Until then the
+// stream counts as an operation in flight, so Close waits for it.
+func (d *Driver) Stream(ctx context.Context, collection string) (<-chan RecordResult, error) {
+	leave, err := d.enter()
+	if err != nil {
+		return nil, err
+	}
+
+	if collection == "" {
+		leave()
+		return nil, fmt.Errorf("missing collection - unable to read")
+	}
+
+	resources, read, err := d.tieredRecords(collection)
+	if err != nil {
+		leave()
+		return nil, err
+	}
+
+	results := make(chan RecordResult)
+
+	go func() {
+		defer leave()
+		defer close(results)
+
+		for _, resource := range resources {
+			if ctx.Err() != nil {
+				return
+			}
+
+			b, err := read(resource)
+
+			select {
+			case results <- RecordResult{Resource: resource, Raw: b, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return results, nil
+}
+
+// Record is a single record returned by ReadAllResults.
+type Record = RecordResult
+
+// ReadAllResults reads every record in collection. A record that cannot be
+// read or is not valid JSON is returned with its Err set rather than failing
+// the whole call; the returned error is reserved for the collection itself.
+func (d *Driver) ReadAllResults(collection string) ([]Record, error) {
+	leave, err := d.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	if collection == "" {
+		return nil, fmt.Errorf("missing collection - unable to read")
+	}
+
+	resources, read, err := d.records(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]Record, 0, len(resources))
+
+	for _, resource := range resources {
+		b, err := read(resource)
+		if err == nil {
+			var raw json.RawMessage
+			if err = json.Unmarshal(b, &raw); err != nil {
+				err = decodeError(collection, resource, b, err)
+			}
+		}
+		records = append(records, Record{Resource: resource, Raw: b, Err: err})
+	}
+	return records, nil
+}
+
+// WriteStream stores the JSON document read from r as a record without
+// holding it in memory: the bytes are validated as they are copied into the
+// temp file, which only replaces the record once they form exactly one
+// valid JSON value. The document is stored byte for byte, compressed under
+// Options.Compress. Under the SingleFile layout, or for Options.Validator,
+// Options.RejectEmpty or encrypted fields, it has to be buffered.
+func (d *Driver) WriteStream(collection, resource string, r io.Reader) error {
+	leave, err := d.enter()
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if collection == "" {
+		return fmt.Errorf("missing collection - no place to save record")
+	}
+	if resource == "" {
+		return fmt.Errorf("missing resource - unable to save record (no name)")
+	}
+	if err := d.checkName(collection, resource); err != nil {
+		return err
+	}
+	if err := d.throttle(context.Background(), collection); err != nil {
+		return err
+	}
+
+	mutex := d.getOrCreateMutex(collection)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	d.warnIfUnknown(collection)
+
+	if d.opts.Layout == SingleFile || d.encrypts(collection) || d.opts.Validator != nil || d.opts.RejectEmpty {
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if !json.Valid(b) {
+			return fmt.Errorf("unable to save %v/%v: %w", collection, resource, validateJSON(bytes.NewReader(b)))
+		}
+		if err := d.checkEncoding(collection, resource, b); err != nil {
+			return err
+		}
+		if err := d.checkRecord(collection, resource, b); err != nil {
+			return err
+		}
+		if b, err = d.sealFields(collection, resource, b); err != nil {
+			return err
+		}
+		_, err = d.writeRecord(collection, resource, b)
+		return err
+	}
+
+	d.buffer.discard(collection, resource)
+	d.forget(collection, resource)
+
+	path := d.recordFile(collection, resource)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return diskError(err)
+	}
+
+	if d.opts.ValidateUTF8 {
+		r = &utf8Reader{r: r}
+	}
+
+	err = d.writeStream(path, func(w io.Writer) error {
+		return validateJSON(io.TeeReader(r, w))
+	})
+	if errors.Is(err, ErrInvalidEncoding) {
+		return fmt.Errorf("%w: %v/%v is not valid UTF-8", ErrInvalidEncoding, collection, resource)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to save %v/%v: %w", collection, resource, err)
+	}
+
+	if err := d.removeStale(collection, resource); err != nil {
+		return err
+	}
+	return d.logChange(collection, false, resource)
+}
+
+// validateJSON reads r to the end, failing unless it holds exactly one JSON
+// value. Only the current token is held in memory.
+func validateJSON(r io.Reader) error {
+	dec := json.NewDecoder(r)
+
+	depth, values := 0, 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			switch {
+			case depth > 0:
+				return io.ErrUnexpectedEOF
+			case values == 0:
+				return fmt.Errorf("no JSON value")
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if depth == 0 && values > 0 {
+			return fmt.Errorf("invalid character after top-level value")
+		}
+
+		if delim, ok := tok.(json.Delim); ok {
+			switch delim {
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+		}
+		if depth == 0 {
+			values++
+		}
+	}
+}
+
+// utf8Reader fails with ErrInvalidEncoding as soon as what it has read from
+// r is not valid UTF-8. A rune split across reads is held back until it is
+// complete.
+type utf8Reader struct {
+	r       io.Reader
+	pending []byte
+}
+
+func (u *utf8Reader) Read(p []byte) (int, error) {
+	n, err := u.r.Read(p)
+
+	data := append(u.pending, p[:n]...)
+	cut := len(data)
+	for i := 1; i < utf8.UTFMax && i <= len(data); i++ {
+		if utf8.RuneStart(data[len(data)-i]) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				cut = len(data) - i
+			}
+			break
+		}
+	}
+
+	if !utf8.Valid(data[:cut]) {
+		return 0, ErrInvalidEncoding
+	}
+	u.pending = append([]byte(nil), data[cut:]...)
+
+	if err == io.EOF && len(u.pending) > 0 {
+		return 0, ErrInvalidEncoding
+	}
+	return n, err
+}
+
+// ExportRecord writes a record to w as plain, indented JSON, whatever form
+// it is stored in, e.g. to share it outside the database.
+func (d *Driver) ExportRecord(collection, resource string, w io.Writer) error {
+	if collection == "" {
+		return fmt.Errorf("missing collection - unable to read")
+	}
+	if resource == "" {
+		return fmt.Errorf("missing resource - unable to read record (no name)")
+	}
+
+	b, err := d.readRaw(collection, resource)
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, bytes.TrimSpace(b), "", "\t"); err != nil {
+		return decodeError(collection, resource, b, err)
+	}
+	out.WriteByte('\n')
+
+	_, err = out.WriteTo(w)
+	return err
+}
+
+// ImportRecord reads a single JSON value from r, such as one written by
+// ExportRecord, and writes it as resource in collection. It is stored the
+// way Write would store it, so Options like Compress apply.
+func (d *Driver) ImportRecord(collection, resource string, r io.Reader) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if err := validateJSON(bytes.NewReader(b)); err != nil {
+		return fmt.Errorf("unable to import %v/%v: %w", collection, resource, err)
+	}
+	return d.Write(collection, resource, json.RawMessage(b))
+}
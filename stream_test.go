@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStreamCancel(t *testing.T) {
+	d := newTestDriver(t)
+	for i := 0; i < 20; i++ {
+		if err := d.Write("events", fmt.Sprintf("e%02d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	results, err := d.Stream(ctx, "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := 0
+	for range results {
+		received++
+		if received == 3 {
+			cancel()
+			break
+		}
+	}
+	// The stream may have one more record in hand when it sees the cancel,
+	// but must not carry on to the end.
+	for range results {
+		received++
+	}
+	if received >= 20 {
+		t.Fatalf("received %d records after cancelling, want the stream to stop early", received)
+	}
+}
+
+func TestStreamAll(t *testing.T) {
+	d := newTestDriver(t)
+	mustWrite(t, d, "events", map[string]interface{}{"a": 1, "b": 2, "c": 3})
+
+	results, err := d.Stream(context.Background(), "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for result := range results {
+		if result.Err != nil {
+			t.Fatal(result.Err)
+		}
+		got = append(got, result.Resource)
+	}
+	if fmt.Sprint(got) != "[a b c]" {
+		t.Fatalf("streamed %v, want [a b c]", got)
+	}
+}
+
+func TestReadAllResultsCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+	writeDemoUsers(t, d)
+	if err := os.WriteFile(filepath.Join(dir, "users", "Harry.json"), []byte(`{"Name": "Har`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := d.ReadAllResults("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(demoUsers()) {
+		t.Fatalf("ReadAllResults returned %d records, want %d", len(records), len(demoUsers()))
+	}
+	for _, record := range records {
+		if record.Resource == "Harry" {
+			if record.Err == nil {
+				t.Error("corrupt Harry has no Err")
+			}
+			continue
+		}
+		var user User
+		if record.Err != nil || json.Unmarshal(record.Raw, &user) != nil || user.Name != record.Resource {
+			t.Errorf("good record %v came back as %q, %v", record.Resource, record.Raw, record.Err)
+		}
+	}
+}
+
+// bigDocument is a JSON object of n entries, several megabytes for large n.
+func bigDocument(n int) string {
+	var b strings.Builder
+	b.WriteString(`{"Items": [`)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `{"ID": %d, "Text": "%s"}`, i, strings.Repeat("x", 100))
+	}
+	b.WriteString(`]}`)
+	return b.String()
+}
+
+func TestWriteStreamLarge(t *testing.T) {
+	for name, options := range map[string][]Option{"plain": nil, "compressed": {WithCompress(true)}} {
+		t.Run(name, func(t *testing.T) {
+			d := newTestDriver(t, options...)
+			doc := bigDocument(40000)
+			if len(doc) < 4<<20 {
+				t.Fatalf("document is only %d bytes", len(doc))
+			}
+
+			if err := d.WriteStream("docs", "big", strings.NewReader(doc)); err != nil {
+				t.Fatal(err)
+			}
+
+			var got struct{ Items []struct{ ID int } }
+			if err := d.Read("docs", "big", &got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Items) != 40000 || got.Items[39999].ID != 39999 {
+				t.Fatalf("read back %d items", len(got.Items))
+			}
+		})
+	}
+}
+
+func TestWriteStreamInvalid(t *testing.T) {
+	d := newTestDriver(t)
+	mustWrite(t, d, "docs", map[string]interface{}{"doc": map[string]int{"v": 1}})
+
+	for _, doc := range []string{`{"v": 2`, `{"v": 2} {"v": 3}`, ``} {
+		if err := d.WriteStream("docs", "doc", strings.NewReader(doc)); err == nil {
+			t.Errorf("WriteStream accepted %q", doc)
+		}
+	}
+
+	var got map[string]int
+	if err := d.Read("docs", "doc", &got); err != nil || got["v"] != 1 {
+		t.Fatalf("record reads as %v, %v after rejected streams", got, err)
+	}
+}
+
+func TestWriteStreamChecks(t *testing.T) {
+	rejectV := func(_, _ string, raw []byte) error {
+		if strings.Contains(string(raw), `"v"`) {
+			return errors.New("v is reserved")
+		}
+		return nil
+	}
+	d := newTestDriver(t, WithRejectEmpty(true), WithValidator(rejectV))
+
+	var verr *ValidationError
+	if err := d.WriteStream("docs", "doc", strings.NewReader(`{"v": 1}`)); !errors.As(err, &verr) {
+		t.Fatalf("WriteStream past the validator returned %v, want a *ValidationError", err)
+	}
+	if err := d.WriteStream("docs", "doc", strings.NewReader("[]\n")); !errors.Is(err, ErrEmptyRecord) {
+		t.Fatalf("WriteStream of an empty record returned %v, want ErrEmptyRecord", err)
+	}
+	if err := d.WriteStream("docs", "doc", strings.NewReader(`{"w": 1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := d.Keys("docs"); err != nil || len(keys) != 1 {
+		t.Fatalf("Keys = %v, %v", keys, err)
+	}
+}
+
+func TestExportImportEncryptedRecord(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir,
+		WithEncryptionKey([]byte("0123456789abcdef")),
+		WithEncryptFields("users", "Contact"))
+	mustWrite(t, d, "users", map[string]interface{}{"Arnab": User{Name: "Arnab", Contact: "322444566"}})
+
+	var exported bytes.Buffer
+	if err := d.ExportRecord("users", "Arnab", &exported); err != nil {
+		t.Fatal(err)
+	}
+	var plain User
+	if err := json.Unmarshal(exported.Bytes(), &plain); err != nil || plain.Contact != "322444566" {
+		t.Fatalf("exported %q, %v", exported.String(), err)
+	}
+
+	if err := d.ImportRecord("users", "Copy", &exported); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, "users", "Copy.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("322444566")) || !bytes.Contains(raw, []byte(encryptedPrefix)) {
+		t.Fatalf("imported record stored as %s", raw)
+	}
+
+	var got User
+	if err := d.Read("users", "Copy", &got); err != nil || got.Contact != "322444566" {
+		t.Fatalf("read back %+v, %v", got, err)
+	}
+}
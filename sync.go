@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// syncer fsyncs the files written under Options.SyncInterval, and the
+// directories holding them, in the background.
+type syncer struct {
+	mutex sync.Mutex
+	// pending maps each path awaiting a sync to whether it is a directory.
+	pending map[string]bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+	once    sync.Once
+}
+
+// startSyncer syncs everything d writes about once per interval until stop
+// is called.
+func startSyncer(d *Driver, interval time.Duration) *syncer {
+	s := &syncer{pending: make(map[string]bool), done: make(chan struct{})}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.sync(); err != nil {
+					d.log.Error("Unable to sync writes: %s\n", err)
+				}
+			case <-s.done:
+				return
+			}
+		}
+	}()
+
+	return s
+}
+
+// markDirty queues path, and the directory its entry lives in, for the next
+// sync. It is safe to call on a nil syncer.
+func (s *syncer) markDirty(path string) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.pending[path] = false
+	s.pending[filepath.Dir(path)] = true
+}
+
+// sync fsyncs everything queued so far, files before directories so a
+// renamed file's data is down before its entry. A path that fails is queued
+// again. It is safe to call on a nil syncer.
+func (s *syncer) sync() error {
+	if s == nil {
+		return nil
+	}
+
+	s.mutex.Lock()
+	pending := s.pending
+	s.pending = make(map[string]bool)
+	s.mutex.Unlock()
+
+	var errs []error
+	for _, dirs := range []bool{false, true} {
+		for path, isDir := range pending {
+			if isDir != dirs {
+				continue
+			}
+			if err := fsync(path); err != nil {
+				errs = append(errs, err)
+				s.mutex.Lock()
+				s.pending[path] = isDir
+				s.mutex.Unlock()
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// stop ends the background sync. It may be called more than once, and on a
+// nil syncer.
+func (s *syncer) stop() {
+	if s == nil {
+		return
+	}
+
+	s.once.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+	})
+}
+
+// fsync flushes path to stable storage. A path removed since it was written
+// has nothing left to sync.
+func fsync(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
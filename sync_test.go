@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// pendingPaths returns the paths s has yet to sync.
+func (s *syncer) pendingPaths() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	paths := make([]string, 0, len(s.pending))
+	for path := range s.pending {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func TestCloseSyncsPendingWrites(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir, WithSyncInterval(time.Hour))
+	writeDemoUsers(t, d)
+
+	pending := map[string]bool{}
+	for _, path := range d.syncer.pendingPaths() {
+		pending[path] = true
+	}
+	if !pending[filepath.Join(dir, "users", "Arnab.json")] || !pending[filepath.Join(dir, "users")] {
+		t.Fatalf("writes queued %q for syncing", d.syncer.pendingPaths())
+	}
+
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if paths := d.syncer.pendingPaths(); len(paths) != 0 {
+		t.Fatalf("Close left %q unsynced", paths)
+	}
+}
+
+func TestFlushSyncsPendingWrites(t *testing.T) {
+	d := newTestDriver(t, WithSyncInterval(time.Hour))
+	writeDemoUsers(t, d)
+
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if paths := d.syncer.pendingPaths(); len(paths) != 0 {
+		t.Fatalf("Flush left %q unsynced", paths)
+	}
+}
+
+func benchmarkSyncedWrites(b *testing.B, d *Driver, each bool) {
+	for i := 0; i < b.N; i++ {
+		if err := d.Write("events", fmt.Sprintf("e%06d", i), map[string]int{"Seq": i}); err != nil {
+			b.Fatal(err)
+		}
+		if each {
+			if err := d.Flush(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.StopTimer()
+	if err := d.Flush(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkWriteSyncEach fsyncs every write, for comparison with
+// BenchmarkWriteSyncInterval.
+func BenchmarkWriteSyncEach(b *testing.B) {
+	benchmarkSyncedWrites(b, newTestDriver(b, WithSyncInterval(time.Hour)), true)
+}
+
+func BenchmarkWriteSyncInterval(b *testing.B) {
+	benchmarkSyncedWrites(b, newTestDriver(b, WithSyncInterval(100*time.Millisecond)), false)
+}
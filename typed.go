@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Typed is a handle on a single collection whose records all decode into T.
+type Typed[T any] struct {
+	d    *Driver
+	name string
+}
+
+// Collection returns a handle bound to the named collection of d.
+func Collection[T any](d *Driver, name string) *Typed[T] {
+	return &Typed[T]{d: d, name: name}
+}
+
+// Get reads a record.
+func (c *Typed[T]) Get(resource string) (T, error) {
+	var v T
+	if err := c.d.Read(c.name, resource, &v); err != nil {
+		var zero T
+		return zero, err
+	}
+	return v, nil
+}
+
+// Put writes a record.
+func (c *Typed[T]) Put(resource string, v T) error {
+	return c.d.Write(c.name, resource, v)
+}
+
+// Delete removes a record. Unlike Driver.Delete it never drops the whole
+// collection.
+func (c *Typed[T]) Delete(resource string) error {
+	if resource == "" {
+		return fmt.Errorf("missing resource - unable to delete record (no name)")
+	}
+	return c.d.Delete(c.name, resource)
+}
+
+// All decodes every record in the collection, in resource name order.
+func (c *Typed[T]) All() ([]T, error) {
+	leave, err := c.d.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	resources, read, err := c.d.records(c.name)
+	if err != nil {
+		return nil, err
+	}
+
+	all := make([]T, 0, len(resources))
+	for _, resource := range resources {
+		b, err := read(resource)
+		if err != nil {
+			return nil, err
+		}
+
+		var v T
+		if err := c.d.decode(b, &v); err != nil {
+			return nil, decodeError(c.name, resource, b, err)
+		}
+		all = append(all, v)
+	}
+	return all, nil
+}
+
+// ReadWithRaw reads a record once, returning it both decoded into T and as
+// the bytes it is stored as, e.g. to forward or sign the canonical form
+// without re-encoding it. The bytes are those of the record file after any
+// decompression; under the SingleFile layout they are the record's entry in
+// the collection file, re-indented.
+func ReadWithRaw[T any](d *Driver, collection, resource string) (T, json.RawMessage, error) {
+	var zero T
+
+	if collection == "" {
+		return zero, nil, fmt.Errorf("missing collection - unable to read")
+	}
+	if resource == "" {
+		return zero, nil, fmt.Errorf("missing resource - unable to read record (no name)")
+	}
+
+	b, err := d.readRaw(collection, resource)
+	if err != nil {
+		return zero, nil, err
+	}
+
+	var v T
+	if err := d.decode(b, &v); err != nil {
+		return zero, nil, decodeError(collection, resource, b, err)
+	}
+	return v, json.RawMessage(b), nil
+}
+
+// CheckSchema decodes every record in collection into T, rejecting unknown
+// fields, and returns the resources that do not fit. It is meant as a
+// preflight before deploying a new version of T. Nothing on disk is
+// modified.
+func CheckSchema[T any](d *Driver, collection string) (mismatches []string, err error) {
+	leave, err := d.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	if collection == "" {
+		return nil, fmt.Errorf("missing collection - unable to check schema")
+	}
+
+	resources, read, err := d.records(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	strict := d.With(WithDisallowUnknownFields(true))
+	for _, resource := range resources {
+		b, err := read(resource)
+		if err != nil {
+			return nil, err
+		}
+
+		var v T
+		if err := strict.decode(b, &v); err != nil {
+			mismatches = append(mismatches, resource)
+		}
+	}
+	return mismatches, nil
+}
+
+// GetOrLoad reads a record, or if it does not exist, stores and returns what
+// loader produces instead. The record lock is held throughout, so of several
+// concurrent callers missing the same record only one calls loader; the
+// others wait and read what it stored. Plain writes of the record wait too.
+func GetOrLoad[T any](d *Driver, collection, resource string, loader func() (T, error)) (T, error) {
+	var zero T
+
+	if loader == nil {
+		return zero, fmt.Errorf("missing loader - unable to load record")
+	}
+
+	d, unlock := d.Lock(collection, resource)
+	defer unlock()
+
+	var v T
+	err := d.Read(collection, resource, &v)
+	if err == nil {
+		return v, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return zero, err
+	}
+
+	v, err = loader()
+	if err != nil {
+		return zero, err
+	}
+	if err := d.Write(collection, resource, v); err != nil {
+		return zero, err
+	}
+	return v, nil
+}
+
+// Archive moves every record of src that decodes into T and satisfies pred
+// to dst, keeping its resource name, and returns how many it moved. Both
+// collection locks are held throughout. Each record is written to dst before
+// it is removed from src, so a failure part way leaves it in both rather than
+// in neither. A record already in dst under the same name is overwritten.
+// Records are checked as writes to dst, so Options.Validator or
+// Options.RejectEmpty refusing one stops the move there.
+// Under Options.DryRun the moves are only logged.
+func Archive[T any](d *Driver, src, dst string, pred func(T) bool) (int, error) {
+	if d.opts.ReadOnly {
+		return 0, ErrReadOnly
+	}
+	if src == "" || dst == "" {
+		return 0, fmt.Errorf("missing collection - unable to archive")
+	}
+	if src == dst {
+		return 0, fmt.Errorf("invalid collection - unable to archive %v into itself", src)
+	}
+	if pred == nil {
+		return 0, fmt.Errorf("missing predicate - unable to archive")
+	}
+
+	// fn keeps running after a timeout, so moved is only looked at once it
+	// has returned.
+	var moved int
+
+	err := d.withTimeout(func() error {
+		unlock := d.lockCollections(src, dst)
+		defer unlock()
+
+		resources, err := d.resources(src)
+		if err != nil {
+			return err
+		}
+
+		d.warnIfUnknown(dst)
+		for _, resource := range resources {
+			b, err := d.readRecord(src, resource)
+			if err != nil {
+				return err
+			}
+
+			var v T
+			if err := d.decode(b, &v); err != nil {
+				return decodeError(src, resource, b, err)
+			}
+			if !pred(v) {
+				continue
+			}
+
+			if err := d.checkRecord(dst, resource, b); err != nil {
+				return err
+			}
+
+			if d.opts.DryRun {
+				d.log.Info("Would move '%s' from '%s' to '%s'\n", resource, src, dst)
+			} else {
+				if err := d.checkName(dst, resource); err != nil {
+					return err
+				}
+				if b, err = d.sealFields(dst, resource, b); err != nil {
+					return err
+				}
+				if _, err := d.writeRecord(dst, resource, b); err != nil {
+					return err
+				}
+				if err := d.deleteRecord(src, resource); err != nil {
+					return err
+				}
+			}
+			moved++
+		}
+		return nil
+	})
+	if errors.Is(err, ErrTimeout) {
+		return 0, err
+	}
+	return moved, err
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTypedCollection(t *testing.T) {
+	d := newTestDriver(t)
+	users := Collection[User](d, "users")
+
+	for _, user := range demoUsers() {
+		if err := users.Put(user.Name, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	harry, err := users.Get("Harry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if harry != demoUsers()[2] {
+		t.Fatalf("Get returned %+v", harry)
+	}
+
+	if err := users.Delete("Harry"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Get("Harry"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get after Delete returned %v, want ErrNotFound", err)
+	}
+	if err := users.Delete(""); err == nil {
+		t.Fatal("Delete without a resource was accepted")
+	}
+
+	all, err := users.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, user := range all {
+		names = append(names, user.Name)
+	}
+	if fmt.Sprint(names) != "[Arnab Jane John Paul Rahul]" {
+		t.Fatalf("All returned %v", names)
+	}
+}
+
+func TestReadWithRaw(t *testing.T) {
+	dir := t.TempDir()
+	d := openTestDriver(t, dir)
+	writeDemoUsers(t, d)
+
+	user, raw, err := ReadWithRaw[User](d, "users", "Arnab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user != demoUsers()[0] {
+		t.Fatalf("decoded %+v", user)
+	}
+
+	file, err := os.ReadFile(filepath.Join(dir, "users", "Arnab.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, file) {
+		t.Fatalf("raw bytes %q differ from the file %q", raw, file)
+	}
+
+	if _, _, err := ReadWithRaw[User](d, "users", "Nobody"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("ReadWithRaw of a missing record returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	d := newTestDriver(t)
+	writeDemoUsers(t, d)
+	mustWrite(t, d, "users", map[string]interface{}{
+		// Age as an object cannot decode into json.Number.
+		"Zed": map[string]interface{}{"Name": "Zed", "Age": map[string]int{"years": 30}},
+		// A field User does not define.
+		"Amy": map[string]interface{}{"Name": "Amy", "Nickname": "A"},
+	})
+
+	mismatches, err := CheckSchema[User](d, "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(mismatches) != "[Amy Zed]" {
+		t.Fatalf("CheckSchema = %v, want [Amy Zed]", mismatches)
+	}
+}
+
+func TestGetOrLoadConcurrentMisses(t *testing.T) {
+	d := newTestDriver(t)
+
+	var (
+		calls   int64
+		start   = make(chan struct{})
+		wg      sync.WaitGroup
+		results = make(chan User, 8)
+	)
+	loader := func() (User, error) {
+		atomic.AddInt64(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		return User{Name: "Arnab", Company: "DAPL"}, nil
+	}
+	for i := 0; i < cap(results); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			user, err := GetOrLoad(d, "users", "Arnab", loader)
+			if err != nil {
+				t.Error(err)
+			}
+			results <- user
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(results)
+
+	if calls != 1 {
+		t.Fatalf("%d concurrent misses called the loader %d times, want once", cap(results), calls)
+	}
+	for user := range results {
+		if user.Company != "DAPL" {
+			t.Fatalf("GetOrLoad returned %+v", user)
+		}
+	}
+
+	// The loaded record is stored, and a failing loader is not consulted.
+	user, err := GetOrLoad(d, "users", "Arnab", func() (User, error) {
+		return User{}, errors.New("loader called on a hit")
+	})
+	if err != nil || user.Company != "DAPL" {
+		t.Fatalf("GetOrLoad on a hit returned %+v, %v", user, err)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	d := newTestDriver(t)
+	for _, user := range demoUsers() {
+		if err := d.Write("active", user.Name, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	older := func(u User) bool {
+		age, _ := u.Age.Int64()
+		return age > 26
+	}
+
+	if moved, err := Archive(d.With(WithDryRun(true)), "active", "archived", older); err != nil || moved != 3 {
+		t.Fatalf("dry run Archive returned %v, %v, want 3", moved, err)
+	}
+	if keys, _ := d.Keys("archived"); len(keys) != 0 {
+		t.Fatalf("dry run Archive moved %v", keys)
+	}
+
+	moved, err := Archive(d, "active", "archived", older)
+	if err != nil || moved != 3 {
+		t.Fatalf("Archive returned %v, %v, want 3", moved, err)
+	}
+	archived, err := d.Keys("archived")
+	if err != nil || fmt.Sprint(archived) != "[Arnab Paul Rahul]" {
+		t.Fatalf("archived holds %v, %v", archived, err)
+	}
+	active, err := d.Keys("active")
+	if err != nil || fmt.Sprint(active) != "[Harry Jane John]" {
+		t.Fatalf("active holds %v, %v", active, err)
+	}
+
+	var user User
+	if err := d.Read("archived", "Arnab", &user); err != nil || user.Company != "DAPL" {
+		t.Fatalf("Read of an archived record returned %+v, %v", user, err)
+	}
+	if moved, err := Archive(d, "active", "archived", older); err != nil || moved != 0 {
+		t.Fatalf("second Archive returned %v, %v, want 0", moved, err)
+	}
+}
+
+func TestArchiveValidatesDestination(t *testing.T) {
+	d := newTestDriver(t, WithValidator(func(collection, _ string, raw []byte) error {
+		if collection == "archived" && bytes.Contains(raw, []byte("Google")) {
+			return errors.New("Google records stay active")
+		}
+		return nil
+	}))
+	writeDemoUsers(t, d)
+
+	var verr *ValidationError
+	moved, err := Archive(d, "users", "archived", func(User) bool { return true })
+	if !errors.As(err, &verr) || verr.Resource != "Harry" {
+		t.Fatalf("Archive returned %v, %v, want a *ValidationError for Harry", moved, err)
+	}
+	if err := d.Read("users", "Harry", &User{}); err != nil {
+		t.Fatalf("rejected record left users: %v", err)
+	}
+	if err := d.Read("archived", "Harry", &User{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("rejected record reached archived: %v", err)
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// UpsertMany writes every record in records, keyed by resource, under a
+// single hold of the collection lock, and reports how many were new and how
+// many replaced an existing record. A record that fails does not stop the
+// others: its error is joined into err, and the counts cover the records
+// that were written. After ErrTimeout the counts are zero, although the
+// records may still be written in the background.
+func (d *Driver) UpsertMany(collection string, records map[string]interface{}) (created, updated int, err error) {
+	leave, err := d.enter()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer leave()
+
+	if d.opts.ReadOnly {
+		return 0, 0, ErrReadOnly
+	}
+	if collection == "" {
+		return 0, 0, fmt.Errorf("missing collection - no place to save records")
+	}
+
+	var errs []error
+
+	encoded := make(map[string][]byte, len(records))
+	for resource, v := range records {
+		if resource == "" {
+			errs = append(errs, fmt.Errorf("missing resource - unable to save record (no name)"))
+			continue
+		}
+		if err := d.checkName(collection, resource); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		b, err := d.marshal(collection, resource, v)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		encoded[resource] = b
+	}
+
+	for range encoded {
+		if err := d.throttle(context.Background(), collection); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	// fn keeps running after a timeout, so its results are only looked at
+	// once it has returned.
+	var (
+		c, u      int
+		writeErrs []error
+	)
+
+	err = d.withTimeout(func() error {
+		mutex := d.getOrCreateMutex(collection)
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		d.warnIfUnknown(collection)
+
+		if d.opts.Layout == SingleFile {
+			return d.upsertCollectionFile(collection, encoded, &c, &u)
+		}
+
+		for _, resource := range sortedResources(encoded) {
+			_, staged := d.buffer.get(collection, resource)
+			_, _, err := d.stat(collection, resource)
+			existed := staged || err == nil
+
+			if _, err := d.writeRecord(collection, resource, encoded[resource]); err != nil {
+				writeErrs = append(writeErrs, fmt.Errorf("unable to save %v/%v: %w", collection, resource, err))
+				continue
+			}
+			if existed {
+				u++
+			} else {
+				c++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, errors.Join(append(errs, err)...)
+	}
+	return c, u, errors.Join(append(errs, writeErrs...)...)
+}
+
+// upsertCollectionFile merges encoded into a SingleFile collection with one
+// rewrite, which either stores every record or none.
+func (d *Driver) upsertCollectionFile(collection string, encoded map[string][]byte, created, updated *int) error {
+	records, err := d.readCollectionFile(collection)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if records == nil {
+		records = make(map[string]json.RawMessage)
+	}
+
+	var c, u int
+	for resource, b := range encoded {
+		_, stored := records[resource]
+		if _, staged := d.buffer.get(collection, resource); stored || staged {
+			u++
+		} else {
+			c++
+		}
+		records[resource] = json.RawMessage(b)
+	}
+
+	if err := d.writeCollectionFile(collection, records); err != nil {
+		return err
+	}
+	for resource := range encoded {
+		d.buffer.discard(collection, resource)
+	}
+	*created, *updated = c, u
+	return d.logChange(collection, false, sortedResources(encoded)...)
+}
+
+// WriteIfAbsent writes the record only if collection does not already hold
+// resource, and reports whether it did. The check and the write happen under
+// one hold of the collection lock, so of several concurrent calls for the
+// same record exactly one writes.
+func (d *Driver) WriteIfAbsent(collection, resource string, v interface{}) (bool, error) {
+	leave, err := d.enter()
+	if err != nil {
+		return false, err
+	}
+	defer leave()
+
+	if d.opts.ReadOnly {
+		return false, ErrReadOnly
+	}
+	if collection == "" {
+		return false, fmt.Errorf("missing collection - no place to save record")
+	}
+	if resource == "" {
+		return false, fmt.Errorf("missing resource - unable to save record (no name)")
+	}
+	if err := d.checkName(collection, resource); err != nil {
+		return false, err
+	}
+
+	b, err := d.marshal(collection, resource, v)
+	if err != nil {
+		return false, err
+	}
+	if err := d.throttle(context.Background(), collection); err != nil {
+		return false, err
+	}
+
+	// fn keeps running after a timeout, so its result is only looked at
+	// once it has returned.
+	var written bool
+	var staged int
+
+	err = d.withTimeout(func() error {
+		mutex := d.getOrCreateMutex(collection)
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		d.warnIfUnknown(collection)
+
+		_, err := d.readRecord(collection, resource)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+
+		if d.buffer != nil {
+			_, staged = d.stageWrite(collection, resource, b)
+		} else if _, err := d.writeRecord(collection, resource, b); err != nil {
+			return err
+		}
+		written = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if err := d.flushIfFull(staged); err != nil {
+		return written, err
+	}
+	return written, nil
+}
+
+// WriteBatchContext writes every record in records, keyed by resource, one
+// at a time in resource name order, calling progress, if set, after each.
+// It stops at the first failure, or once ctx is cancelled between records,
+// returning ctx.Err(). Either way the records already written stay written,
+// each of them whole, so the collection holds a prefix of the batch.
+func (d *Driver) WriteBatchContext(ctx context.Context, collection string, records map[string]interface{}, progress func(done, total int)) error {
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if collection == "" {
+		return fmt.Errorf("missing collection - no place to save records")
+	}
+
+	resources := sortedResources(records)
+	for i, resource := range resources {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := d.writeEx(ctx, collection, resource, records[resource]); err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(i+1, len(resources))
+		}
+	}
+	return nil
+}
+
+// maxInsertAttempts bounds how many IDs Insert tries before giving up on a
+// generator that keeps returning names already taken.
+const maxInsertAttempts = 10
+
+// Insert writes v under a new name from Options.IDGenerator and returns it.
+// The name is picked under the collection lock, and one that is already
+// taken is replaced by the next the generator offers.
+func (d *Driver) Insert(collection string, v interface{}) (string, error) {
+	if d.opts.ReadOnly {
+		return "", ErrReadOnly
+	}
+	if collection == "" {
+		return "", fmt.Errorf("missing collection - no place to save record")
+	}
+	if err := d.throttle(context.Background(), collection); err != nil {
+		return "", err
+	}
+
+	// fn keeps running after a timeout, so its result is only looked at
+	// once it has returned.
+	var id string
+	var staged int
+
+	err := d.withTimeout(func() error {
+		mutex := d.getOrCreateMutex(collection)
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		d.warnIfUnknown(collection)
+
+		resource, err := d.newID(collection)
+		if err != nil {
+			return err
+		}
+
+		b, err := d.marshal(collection, resource, v)
+		if err != nil {
+			return err
+		}
+
+		if d.buffer != nil {
+			_, staged = d.stageWrite(collection, resource, b)
+		} else if _, err := d.writeRecord(collection, resource, b); err != nil {
+			return err
+		}
+		id = resource
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := d.flushIfFull(staged); err != nil {
+		return id, err
+	}
+	return id, nil
+}
+
+// newID returns a name from Options.IDGenerator that collection does not
+// hold yet. The caller must hold the collection lock.
+func (d *Driver) newID(collection string) (string, error) {
+	for attempt := 0; attempt < maxInsertAttempts; attempt++ {
+		resource := d.opts.IDGenerator()
+		if resource == "" {
+			return "", fmt.Errorf("missing resource - IDGenerator returned an empty name")
+		}
+		if err := d.checkName(collection, resource); err != nil {
+			return "", err
+		}
+
+		_, err := d.readRecord(collection, resource)
+		if errors.Is(err, ErrNotFound) {
+			return resource, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("%w: no free name in %v after %d attempts", ErrExists, collection, maxInsertAttempts)
+}
+
+// newUUID returns a random (version 4) UUID, the default
+// Options.IDGenerator.
+func newUUID() string {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		panic(err)
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestUpsertMany(t *testing.T) {
+	d := newTestDriver(t)
+	writeDemoUsers(t, d)
+
+	created, updated, err := d.UpsertMany("users", map[string]interface{}{
+		"Arnab": User{Name: "Arnab", Company: "Acme"},
+		"Jane":  User{Name: "Jane", Company: "Acme"},
+		"Zed":   User{Name: "Zed"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != 1 || updated != 2 {
+		t.Fatalf("UpsertMany created %d and updated %d, want 1 and 2", created, updated)
+	}
+
+	var user User
+	if err := d.Read("users", "Jane", &user); err != nil || user.Company != "Acme" {
+		t.Fatalf("Jane reads as %+v, %v", user, err)
+	}
+}
+
+func TestUpsertManyPartialFailure(t *testing.T) {
+	d := newTestDriver(t)
+	writeDemoUsers(t, d)
+
+	type Reading struct{ Value float64 }
+	created, updated, err := d.UpsertMany("users", map[string]interface{}{
+		"Arnab": demoUsers()[0],
+		"Zed":   User{Name: "Zed"},
+		"Bad":   Reading{Value: math.NaN()},
+	})
+	if !errors.Is(err, ErrUnsupportedValue) {
+		t.Fatalf("UpsertMany returned %v, want ErrUnsupportedValue joined in", err)
+	}
+	if created != 1 || updated != 1 {
+		t.Fatalf("UpsertMany created %d and updated %d, want 1 and 1", created, updated)
+	}
+	if err := d.Read("users", "Bad", &Reading{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("the failed record was written: %v", err)
+	}
+}
+
+func TestUpsertManyBuffered(t *testing.T) {
+	d := newTestDriver(t, WithBufferWrites(0, 0))
+	mustWrite(t, d, "users", map[string]interface{}{"Arnab": demoUsers()[0]})
+
+	// The staged record counts as existing before it is flushed.
+	created, updated, err := d.UpsertMany("users", map[string]interface{}{"Arnab": demoUsers()[0]})
+	if err != nil || created != 0 || updated != 1 {
+		t.Fatalf("UpsertMany over a staged record = %d created, %d updated, %v", created, updated, err)
+	}
+}
+
+func TestWriteIfAbsentConcurrent(t *testing.T) {
+	d := newTestDriver(t)
+
+	var wg sync.WaitGroup
+	wrote := make([]bool, 8)
+	for i := range wrote {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ok, err := d.WriteIfAbsent("settings", "defaults", map[string]int{"writer": i})
+			if err != nil {
+				t.Error(err)
+			}
+			wrote[i] = ok
+		}(i)
+	}
+	wg.Wait()
+
+	winner := -1
+	for i, ok := range wrote {
+		if ok {
+			if winner >= 0 {
+				t.Fatalf("writers %d and %d both wrote", winner, i)
+			}
+			winner = i
+		}
+	}
+	if winner < 0 {
+		t.Fatal("no writer wrote")
+	}
+
+	var got map[string]int
+	if err := d.Read("settings", "defaults", &got); err != nil || got["writer"] != winner {
+		t.Fatalf("record is %v, %v; want writer %d's", got, err, winner)
+	}
+}
+
+// sequence returns an IDGenerator that hands out ids in turn, then repeats
+// the last one.
+func sequence(ids ...string) func() string {
+	var mutex sync.Mutex
+	return func() string {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		id := ids[0]
+		if len(ids) > 1 {
+			ids = ids[1:]
+		}
+		return id
+	}
+}
+
+func TestInsertIDGenerator(t *testing.T) {
+	d := newTestDriver(t, WithIDGenerator(sequence("Arnab", "John", "u1", "u2")))
+	mustWrite(t, d, "users", map[string]interface{}{
+		"Arnab": User{Name: "Arnab"},
+		"John":  User{Name: "John"},
+	})
+
+	// The first two ids are taken, so Insert moves on to u1.
+	id, err := d.Insert("users", User{Name: "Harry"})
+	if err != nil || id != "u1" {
+		t.Fatalf("Insert returned %q, %v, want u1", id, err)
+	}
+	var user User
+	if err := d.Read("users", "Arnab", &user); err != nil || user.Name != "Arnab" {
+		t.Fatalf("Insert replaced an existing record: %+v, %v", user, err)
+	}
+	if err := d.Read("users", "u1", &user); err != nil || user.Name != "Harry" {
+		t.Fatalf("Read of the inserted record returned %+v, %v", user, err)
+	}
+
+	if id, err := d.Insert("users", User{Name: "Paul"}); err != nil || id != "u2" {
+		t.Fatalf("second Insert returned %q, %v, want u2", id, err)
+	}
+	// From here on the generator only offers u2, which is taken.
+	if _, err := d.Insert("users", User{Name: "Rahul"}); !errors.Is(err, ErrExists) {
+		t.Fatalf("Insert with no free id returned %v, want ErrExists", err)
+	}
+}
+
+func TestInsertDefaultIDs(t *testing.T) {
+	d := newTestDriver(t)
+
+	seen := map[string]bool{}
+	for i := 0; i < 10; i++ {
+		id, err := d.Insert("users", User{Name: "Arnab"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(id) != 36 || seen[id] {
+			t.Fatalf("Insert returned id %q", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestWriteBatchContextCancel(t *testing.T) {
+	d := newTestDriver(t)
+
+	records := map[string]interface{}{}
+	for i := 0; i < 10; i++ {
+		records[fmt.Sprintf("e%02d", i)] = map[string]int{"Seq": i}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var reports []int
+	err := d.WriteBatchContext(ctx, "events", records, func(done, total int) {
+		if total != len(records) {
+			t.Errorf("progress reported a total of %d, want %d", total, len(records))
+		}
+		reports = append(reports, done)
+		if done == 4 {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled WriteBatchContext returned %v, want context.Canceled", err)
+	}
+	if fmt.Sprint(reports) != "[1 2 3 4]" {
+		t.Fatalf("progress reported %v", reports)
+	}
+
+	// The records written are the first four by name, each of them whole.
+	keys, err := d.Keys("events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(keys) != "[e00 e01 e02 e03]" {
+		t.Fatalf("cancelled batch left %v", keys)
+	}
+	for i, key := range keys {
+		var event map[string]int
+		if err := d.Read("events", key, &event); err != nil || event["Seq"] != i {
+			t.Fatalf("Read(%v) returned %v, %v", key, event, err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ValidationError reports every problem a validator found with a record, so
+// they can all be shown at once. Validators passed to Validate or set as
+// Options.Validator may return one; the Resource is filled in if they leave
+// it empty.
+type ValidationError struct {
+	Resource string
+	Issues   []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid record %v: %v", e.Resource, strings.Join(e.Issues, "; "))
+}
+
+// asValidationError returns err as a *ValidationError for resource, turning
+// any other error into one with a single issue.
+func asValidationError(resource string, err error) *ValidationError {
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		return &ValidationError{Resource: resource, Issues: []string{err.Error()}}
+	}
+	if verr.Resource == "" {
+		named := *verr
+		named.Resource = resource
+		return &named
+	}
+	return verr
+}
+
+// Validate runs validator over every record in collection and returns the
+// resources it rejected. Nothing on disk is modified.
+func (d *Driver) Validate(collection string, validator func(raw []byte) error) (invalid []string, err error) {
+	report, err := d.ValidateReport(collection, validator)
+	if err != nil {
+		return nil, err
+	}
+	for _, verr := range report {
+		invalid = append(invalid, verr.Resource)
+	}
+	return invalid, nil
+}
+
+// ValidateReport is Validate returning every issue found with each rejected
+// record rather than just its name.
+func (d *Driver) ValidateReport(collection string, validator func(raw []byte) error) ([]*ValidationError, error) {
+	leave, err := d.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	if collection == "" {
+		return nil, fmt.Errorf("missing collection - unable to validate")
+	}
+	if validator == nil {
+		return nil, fmt.Errorf("missing validator - unable to validate")
+	}
+
+	resources, read, err := d.records(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	var report []*ValidationError
+	for _, resource := range resources {
+		b, err := read(resource)
+		if err != nil {
+			return nil, err
+		}
+		if err := validator(b); err != nil {
+			report = append(report, asValidationError(resource, err))
+		}
+	}
+	return report, nil
+}
+
+// validate runs Options.Validator over a record about to be written.
+func (d *Driver) validate(collection, resource string, b []byte) error {
+	if d.opts.Validator == nil {
+		return nil
+	}
+	if err := d.opts.Validator(collection, resource, b); err != nil {
+		return asValidationError(resource, err)
+	}
+	return nil
+}
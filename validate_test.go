@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// requireCompany rejects users without a company.
+func requireCompany(raw []byte) error {
+	var user map[string]interface{}
+	if err := json.Unmarshal(raw, &user); err != nil {
+		return err
+	}
+	if user["Company"] == nil {
+		return errors.New("missing company")
+	}
+	return nil
+}
+
+func TestValidateMissingField(t *testing.T) {
+	d := newTestDriver(t)
+	writeDemoUsers(t, d)
+	mustWrite(t, d, "users", map[string]interface{}{
+		"Zed": map[string]string{"Name": "Zed"},
+		"Amy": map[string]string{"Name": "Amy"},
+	})
+
+	invalid, err := d.Validate("users", requireCompany)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(invalid) != "[Amy Zed]" {
+		t.Fatalf("Validate flagged %v, want [Amy Zed]", invalid)
+	}
+
+	// Nothing is changed: the invalid records are still there as written.
+	var zed map[string]string
+	if err := d.Read("users", "Zed", &zed); err != nil {
+		t.Fatal(err)
+	}
+	if len(zed) != 1 || zed["Name"] != "Zed" {
+		t.Fatalf("Zed was modified to %v", zed)
+	}
+}
+
+func TestValidateReport(t *testing.T) {
+	d := newTestDriver(t)
+	mustWrite(t, d, "users", map[string]interface{}{"Amy": map[string]string{"Name": "Amy"}})
+
+	report, err := d.ValidateReport("users", func(raw []byte) error {
+		return &ValidationError{Issues: []string{"missing company", "missing age"}}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report) != 1 || report[0].Resource != "Amy" || len(report[0].Issues) != 2 {
+		t.Fatalf("ValidateReport returned %+v", report)
+	}
+}
+
+// userRules checks a user against three rules, reporting every one broken.
+func userRules(raw []byte) error {
+	var user User
+	if err := json.Unmarshal(raw, &user); err != nil {
+		return err
+	}
+	var issues []string
+	if user.Company == "" {
+		issues = append(issues, "company is required")
+	}
+	if user.Contact == "" {
+		issues = append(issues, "contact is required")
+	}
+	if user.Address.Country == "" {
+		issues = append(issues, "country is required")
+	}
+	if len(issues) > 0 {
+		return &ValidationError{Issues: issues}
+	}
+	return nil
+}
+
+func TestValidationErrorAllIssues(t *testing.T) {
+	d := newTestDriver(t, WithValidator(func(collection, resource string, b []byte) error {
+		return userRules(b)
+	}))
+	writeDemoUsers(t, d)
+
+	err := d.Write("users", "Zed", User{Name: "Zed"})
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Write of an invalid user returned %v, want a ValidationError", err)
+	}
+	if verr.Resource != "Zed" || len(verr.Issues) != 3 {
+		t.Fatalf("ValidationError = %+v, want three issues for Zed", verr)
+	}
+	if err := d.Read("users", "Zed", &User{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("invalid user was stored: %v", err)
+	}
+
+	report, err := d.ValidateReport("users", userRules)
+	if err != nil || len(report) != 0 {
+		t.Fatalf("ValidateReport over valid users = %v, %v", report, err)
+	}
+}